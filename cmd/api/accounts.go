@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type     string `json:"type"`
+		Currency string `json:"currency"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	account := &data.Account{
+		Type:     input.Type,
+		Currency: input.Currency,
+		Status:   data.AccountStatusActive,
+		UserID:   user.ID,
+	}
+
+	err = account.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	err = app.models.Accounts.Insert(r.Context(), account)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAccount):
+			app.failedValidationResponse(w, r, map[string]string{"currency": "you already have an open account in this currency"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/accounts/%d", account.ID))
+
+	err = app.WriteJSON(w, r, Envelope{"account": account}, headers, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	accounts, err := app.models.Accounts.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"accounts": accounts}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ownAccount loads the account named by the :id parameter. Accounts of other
+// users are reported as not found, so their ids can't be probed
+func (app *application) ownAccount(w http.ResponseWriter, r *http.Request) *data.Account {
+	id, err := app.ParseParams(w, r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return nil
+	}
+
+	account, err := app.models.Accounts.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if account.UserID != app.contextGetUser(r).ID {
+		app.notFoundErrorResponse(w, r)
+		return nil
+	}
+
+	return account
+}
+
+func (app *application) showAccountHandler(w http.ResponseWriter, r *http.Request) {
+	account := app.ownAccount(w, r)
+	if account == nil {
+		return
+	}
+
+	err := app.WriteJSON(w, r, Envelope{"account": account}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) closeAccountHandler(w http.ResponseWriter, r *http.Request) {
+	account := app.ownAccount(w, r)
+	if account == nil {
+		return
+	}
+
+	if account.Status == data.AccountStatusClosed {
+		app.failedValidationResponse(w, r, map[string]string{"status": "the account is already closed"})
+		return
+	}
+
+	err := app.models.Accounts.Close(r.Context(), account)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAccountNotEmpty):
+			app.failedValidationResponse(w, r, map[string]string{"balance": "the account must be empty, with nothing held, before it can be closed"})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"account": account}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/julienschmidt/httprouter"
+)
+
+// audit records an admin action with models, which should be the transaction
+// making the change so the two can't get out of step. The acting user comes
+// from the request
+func (app *application) audit(r *http.Request, models data.Models, action string, targetUserID int64, details map[string]any) error {
+	entry := &data.AuditEntry{
+		ActorID: app.contextGetUser(r).ID,
+		Action:  action,
+		Details: details,
+	}
+
+	if targetUserID != 0 {
+		entry.TargetUserID = &targetUserID
+	}
+
+	return models.Audit.Insert(r.Context(), entry)
+}
+
+// targetUser loads the user named by the :id parameter, writing the error
+// response and returning nil when that isn't possible
+func (app *application) targetUser(w http.ResponseWriter, r *http.Request) *data.Users {
+	id, err := app.ParseParams(w, r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return nil
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.audit(r, app.models, "permissions.list", 0, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"permissions": permissions}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = data.ValidatePermissionCode(input.Code)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"code": err.Error()})
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Permissions.Insert(r.Context(), input.Code)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "permissions.create", 0, map[string]any{"code": input.Code})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePermission):
+			app.failedValidationResponse(w, r, map[string]string{"code": "this permission already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"permission": input.Code}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Permissions.GetAllRoles(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.audit(r, app.models, "roles.list", 0, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"roles": roles}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.targetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.audit(r, app.models, "users.permissions.show", user.ID, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// responds with the roles, the directly granted permissions and the
+// resulting effective permissions of the user
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.Users) {
+	roles, err := app.models.Permissions.GetRolesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	effective, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := Envelope{
+		"user_id":               user.ID,
+		"roles":                 roles,
+		"permissions":           direct,
+		"effective_permissions": effective,
+	}
+
+	err = app.WriteJSON(w, r, env, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(input.Codes) == 0 {
+		app.failedValidationResponse(w, r, map[string]string{"codes": "must contain at least one permission"})
+		return
+	}
+
+	user := app.targetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Permissions.AddForUser(r.Context(), user.ID, input.Codes...)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "users.permissions.grant", user.ID, map[string]any{"codes": input.Codes})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownPermission):
+			app.failedValidationResponse(w, r, map[string]string{"codes": "contains a permission that doesn't exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.targetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	err := app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Permissions.RemoveForUser(r.Context(), user.ID, code)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "users.permissions.revoke", user.ID, map[string]any{"code": code})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(input.Roles) == 0 {
+		app.failedValidationResponse(w, r, map[string]string{"roles": "must contain at least one role"})
+		return
+	}
+
+	user := app.targetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Permissions.AddRolesForUser(r.Context(), user.ID, input.Roles...)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "users.roles.assign", user.ID, map[string]any{"roles": input.Roles})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownRole):
+			app.failedValidationResponse(w, r, map[string]string{"roles": "contains a role that doesn't exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.targetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	err := app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Permissions.RemoveRolesForUser(r.Context(), user.ID, role)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "users.roles.remove", user.ID, map[string]any{"role": role})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var targetUserID int64
+
+	if s := r.URL.Query().Get("user_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			app.failedValidationResponse(w, r, map[string]string{"user_id": "must be a positive integer"})
+			return
+		}
+		targetUserID = id
+	}
+
+	entries, err := app.models.Audit.GetAll(r.Context(), targetUserID, 100)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"audit_log": entries}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
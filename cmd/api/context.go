@@ -8,7 +8,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	claimsContextKey = contextKey("claims")
+)
 
 func (app *application) contextSetUser(r *http.Request, data *data.Users) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, data)
@@ -23,3 +26,14 @@ func (app *application) contextGetUser(r *http.Request) *data.Users {
 
 	return user
 }
+
+func (app *application) contextSetClaims(r *http.Request, claims *jwtClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// claims are only present when the request was authenticated with a JWT
+func (app *application) contextGetClaims(r *http.Request) (*jwtClaims, bool) {
+	claims, ok := r.Context().Value(claimsContextKey).(*jwtClaims)
+	return claims, ok
+}
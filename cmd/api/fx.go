@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	fxQuoteTTL     = 60 * time.Second
+	maxFXRatesSize = 1 << 20
+)
+
+// uploadFXRatesHandler takes a CSV with a header row naming the columns base,
+// quote, rate and optionally effective_at (RFC 3339, defaults to now). The
+// whole file is stored or, if any line is invalid, none of it
+func (app *application) uploadFXRatesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFXRatesSize)
+
+	rates, errs, err := readFXRates(r.Body, time.Now().Truncate(time.Second))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.FXRates.InsertMany(r.Context(), rates)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "fx.rates.upload", 0, map[string]any{"rates": len(rates)})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"rates": rates}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readFXRates parses the uploaded CSV. Problems with single lines are
+// collected per line number so they can all be fixed in one go
+func readFXRates(body io.Reader, now time.Time) ([]*data.FXRate, map[string]string, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("body must not be empty")
+		}
+		return nil, nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"base", "quote", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("header must have a %q column", name)
+		}
+	}
+
+	rates := []*data.FXRate{}
+	errs := make(map[string]string)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		key := fmt.Sprintf("line %d", line)
+
+		rate := &data.FXRate{
+			Base:        strings.ToUpper(record[columns["base"]]),
+			Quote:       strings.ToUpper(record[columns["quote"]]),
+			EffectiveAt: now,
+		}
+
+		rate.Rate, err = data.ParseRate(record[columns["rate"]])
+		if err != nil {
+			errs[key] = err.Error()
+			continue
+		}
+
+		if i, ok := columns["effective_at"]; ok && record[i] != "" {
+			rate.EffectiveAt, err = time.Parse(time.RFC3339, record[i])
+			if err != nil {
+				errs[key] = "effective_at must be an RFC 3339 timestamp"
+				continue
+			}
+		}
+
+		err = rate.Validate()
+		if err != nil {
+			errs[key] = err.Error()
+			continue
+		}
+
+		rates = append(rates, rate)
+	}
+
+	if len(rates) == 0 && len(errs) == 0 {
+		return nil, nil, errors.New("body must contain at least one rate")
+	}
+
+	return rates, errs, nil
+}
+
+func (app *application) createFXQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Amount     data.Money `json:"amount"`
+		ToCurrency string     `json:"to_currency"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.ToCurrency = strings.ToUpper(input.ToCurrency)
+
+	err = validation.Errors{
+		"amount":      validation.Validate(input.Amount, data.PositiveMoney),
+		"to_currency": validation.Validate(input.ToCurrency, validation.Required, is.CurrencyCode, validation.NotIn(input.Amount.Currency()).Error(data.ErrSameCurrency.Error())),
+	}.Filter()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	rate, err := app.models.FXRates.Current(r.Context(), input.Amount.Currency(), input.ToCurrency, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"to_currency": "no exchange rate for this currency pair"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	quote, err := app.models.FXQuotes.New(r.Context(), user.ID, input.Amount, rate, fxQuoteTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAmountTooSmall):
+			app.failedValidationResponse(w, r, map[string]string{"amount": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"quote": quote}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeems a quote from createFXQuoteHandler, moving the money between the
+// user's balances in the two currencies
+func (app *application) createFXConversionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		QuoteToken string `json:"quote_token"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	token := &data.Token{
+		Token: input.QuoteToken,
+	}
+
+	if err := token.Validate(); err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"quote_token": err.Error()})
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	quote, err := app.models.FXQuotes.Redeem(r.Context(), input.QuoteToken, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"quote_token": "invalid, expired or already used quote"})
+		case errors.Is(err, data.ErrInsufficientFunds):
+			app.failedValidationResponse(w, r, map[string]string{"amount": "insufficient funds"})
+		case errors.Is(err, data.ErrAccountNotActive):
+			app.failedValidationResponse(w, r, map[string]string{"account": "the account is frozen or closed"})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"conversion": quote}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
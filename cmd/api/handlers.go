@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5"
 )
 
 func (app *application) healthcheck(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +56,24 @@ func (app *application) registerUserHanlder(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// the user, their roles and the activation token are created together or
+	// not at all
+	var token *data.Token
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddRolesForUser(r.Context(), user.ID, app.config.defaultRoles...)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -62,26 +84,65 @@ func (app *application) registerUserHanlder(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	err = app.WriteJSON(w, r, Envelope{"user": user, "activation_token": token}, nil, http.StatusCreated)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenString string `json:"token"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// repeatable read so two requests racing with the same token can't both
+	// activate, the loser is retried and then finds the token gone
+	var user *data.Users
+
+	err = app.models.WithTx(r.Context(), pgx.RepeatableRead, func(tx data.Models) error {
+		var err error
+
+		user, err = tx.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenString)
+		if err != nil {
+			return err
+		}
+
+		user.Activated = true
+
+		err = tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteForUser(r.Context(), data.ScopeActivation, user.ID)
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"error": "invalid token"})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.WriteJSON(w, r, Envelope{"user": user, "activation_token": token}, nil, http.StatusCreated)
+	err = app.WriteJSON(w, r, Envelope{"user": user}, nil, http.StatusOK)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		Password    string `json:"password"`
 		TokenString string `json:"token"`
 	}
 
@@ -91,20 +152,147 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenString)
+	token := &data.Token{
+		Token: input.TokenString,
+	}
+
+	if err := token.Validate(); err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"token": "invalid or expired password reset token"})
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenString)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.failedValidationResponse(w, r, map[string]string{"error": "invalid token"})
+			app.failedValidationResponse(w, r, map[string]string{"token": "invalid or expired password reset token"})
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	user.Activated = true
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = user.Password.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// whoever knew the old password shouldn't stay logged in
+	err = app.revokeAllSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"message": "your password was successfully reset"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updates the profile of the current user. A new email address isn't applied
+// straight away, it's kept as pending until confirmed from the new inbox
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.Name != nil {
+		err = validation.Validate(*input.Name, validation.Required)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"name": err.Error()})
+			return
+		}
+
+		user.Username = *input.Name
+	}
+
+	var newEmail string
+
+	if input.Email != nil && !strings.EqualFold(*input.Email, user.Email) {
+		newEmail = *input.Email
 
-	err = app.models.Users.Update(user)
+		err = validation.Validate(newEmail, validation.Required, is.Email)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"email": err.Error()})
+			return
+		}
+
+		// a stolen access token alone mustn't be enough to take the account over
+		if input.CurrentPassword == "" {
+			app.failedValidationResponse(w, r, map[string]string{"current_password": "must be provided to change the email address"})
+			return
+		}
+
+		if app.userThrottled(w, r, user.ID) {
+			return
+		}
+
+		matches, err := user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !matches {
+			app.loginFailed(w, r, user)
+			return
+		}
+	}
+
+	var token *data.Token
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		if input.Name != nil {
+			err := tx.Users.Update(r.Context(), user)
+			if err != nil {
+				return err
+			}
+		}
+
+		if newEmail != "" {
+			var err error
+
+			token, err = tx.EmailChanges.New(r.Context(), user.ID, newEmail, 24*time.Hour)
+			return err
+		}
+
+		return nil
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -115,10 +303,94 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteForUser(data.ScopeActivation, user.ID)
+	env := Envelope{"user": user}
+
+	if newEmail != "" {
+		oldEmail := user.Email
+
+		app.background(func() {
+			data := map[string]any{
+				"email":            newEmail,
+				"emailChangeToken": token.Token,
+			}
+
+			err := app.mailer.Send(newEmail, "email_change_confirm.tmpl", data)
+			if err != nil {
+				app.log.Error(err.Error())
+			}
+
+			err = app.mailer.Send(oldEmail, "email_change_notice.tmpl", data)
+			if err != nil {
+				app.log.Error(err.Error())
+			}
+		})
+
+		env["pending_email"] = newEmail
+		env["message"] = "a confirmation email has been sent to the new address"
+	}
+
+	err = app.WriteJSON(w, r, env, nil, http.StatusOK)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenString string `json:"token"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	token := &data.Token{
+		Token: input.TokenString,
+	}
+
+	if err := token.Validate(); err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"token": "invalid or expired email change token"})
+		return
+	}
+
+	// same as activation, a token used twice at once only changes the email once
+	var user *data.Users
+
+	err = app.models.WithTx(r.Context(), pgx.RepeatableRead, func(tx data.Models) error {
+		var err error
+
+		user, err = tx.Users.GetForToken(r.Context(), data.ScopeEmailChange, input.TokenString)
+		if err != nil {
+			return err
+		}
+
+		user.Email, err = tx.EmailChanges.GetForToken(r.Context(), input.TokenString)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteForUser(r.Context(), data.ScopeEmailChange, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"token": "invalid or expired email change token"})
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.failedValidationResponse(w, r, map[string]string{"email": "a user with the email already exists"})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
 	err = app.WriteJSON(w, r, Envelope{"user": user}, nil, http.StatusOK)
 	if err != nil {
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRegisterUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.register(t, "Alice", "alice@example.com", "pa55word1234")
+
+	tests := []struct {
+		name       string
+		body       any
+		wantStatus int
+	}{
+		{
+			name:       "valid",
+			body:       map[string]string{"name": "Bob", "email": "bob@example.com", "password": "pa55word1234"},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "duplicate email",
+			body:       map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "duplicate email in another case",
+			body:       map[string]string{"name": "Alice", "email": "ALICE@example.com", "password": "pa55word1234"},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "invalid email",
+			body:       map[string]string{"name": "Carol", "email": "carol", "password": "pa55word1234"},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "missing name",
+			body:       map[string]string{"email": "dave@example.com", "password": "pa55word1234"},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "unknown field",
+			body:       map[string]string{"name": "Erin", "email": "erin@example.com", "password": "pa55word1234", "admin": "true"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := ts.do(t, http.MethodPost, "/v1/users", tt.body, "")
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+		})
+	}
+}
+
+func TestRegisterUserAssignsDefaultRoles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.register(t, "Alice", "alice@example.com", "pa55word1234")
+
+	user, err := app.models.Users.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Activated {
+		t.Error("new user is activated")
+	}
+
+	roles, err := app.models.Permissions.GetRolesForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(roles) != 1 || roles[0] != "customer" {
+		t.Errorf("got roles %v, want [customer]", roles)
+	}
+}
+
+func TestActivateUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	token := ts.register(t, "Alice", "alice@example.com", "pa55word1234")
+
+	status, resp := ts.do(t, http.MethodPut, "/v1/users/activated", map[string]string{"token": token}, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %v", status, http.StatusOK, resp)
+	}
+
+	if !field[bool](t, resp, "user", "activated") {
+		t.Errorf("user not activated: %v", resp)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"token already used", token},
+		{"unknown token", "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+		{"empty token", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := ts.do(t, http.MethodPut, "/v1/users/activated", map[string]string{"token": tt.token}, "")
+			if status != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d: %v", status, http.StatusUnprocessableEntity, resp)
+			}
+		})
+	}
+}
+
+func TestActivateUserExpiredToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.register(t, "Alice", "alice@example.com", "pa55word1234")
+
+	user, err := app.models.Users.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(context.Background(), user.ID, -1, data.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := ts.do(t, http.MethodPut, "/v1/users/activated", map[string]string{"token": token.Token}, "")
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d, want %d: %v", status, http.StatusUnprocessableEntity, resp)
+	}
+}
+
+func TestCreateJWTToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.activate(t, ts.register(t, "Alice", "alice@example.com", "pa55word1234"))
+	ts.register(t, "Bob", "bob@example.com", "pa55word1234")
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantStatus int
+	}{
+		{"valid", "alice@example.com", "pa55word1234", http.StatusCreated},
+		{"wrong password", "alice@example.com", "wrong-password", http.StatusUnauthorized},
+		{"unknown email", "nobody@example.com", "pa55word1234", http.StatusUnauthorized},
+		{"not activated", "bob@example.com", "pa55word1234", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{
+				"email":    tt.email,
+				"password": tt.password,
+			}, "")
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+
+			if status == http.StatusCreated {
+				if field[string](t, resp, "access_token") == "" || field[string](t, resp, "refresh_token") == "" {
+					t.Errorf("missing tokens: %v", resp)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.activate(t, ts.register(t, "Alice", "alice@example.com", "pa55word1234"))
+	ts.register(t, "Bob", "bob@example.com", "pa55word1234")
+
+	login := func(email string) map[string]any {
+		status, resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{
+			"email":    email,
+			"password": "pa55word1234",
+		}, "")
+		if status != http.StatusCreated {
+			t.Fatalf("logging in %s: got status %d, %v", email, status, resp)
+		}
+		return resp
+	}
+
+	alice := login("alice@example.com")
+	bob := login("bob@example.com")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"activated user", field[string](t, alice, "access_token"), http.StatusOK},
+		{"inactive user", field[string](t, bob, "access_token"), http.StatusForbidden},
+		{"no token", "", http.StatusUnauthorized},
+		{"garbage token", "not-a-jwt", http.StatusUnauthorized},
+		{"refresh token instead of access token", field[string](t, alice, "refresh_token"), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := ts.do(t, http.MethodGet, "/v1/healthcheck", nil, tt.token)
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+		})
+	}
+}
+
+func TestRefreshJWTToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.activate(t, ts.register(t, "Alice", "alice@example.com", "pa55word1234"))
+
+	status, resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{
+		"email":    "alice@example.com",
+		"password": "pa55word1234",
+	}, "")
+	if status != http.StatusCreated {
+		t.Fatalf("logging in: got status %d, %v", status, resp)
+	}
+
+	first := field[string](t, resp, "refresh_token")
+
+	status, resp = ts.do(t, http.MethodPost, "/v1/tokens/refresh", map[string]string{"refresh_token": first}, "")
+	if status != http.StatusCreated {
+		t.Fatalf("refreshing: got status %d, %v", status, resp)
+	}
+
+	second := field[string](t, resp, "refresh_token")
+
+	// using the first token again revokes the whole family, second included
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", map[string]string{"refresh_token": first}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("reusing a refresh token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", map[string]string{"refresh_token": second}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("refreshing after reuse: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginAfterLogoutEverywhere(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.activate(t, ts.register(t, "Alice", "alice@example.com", "pa55word1234"))
+
+	login := func() string {
+		status, resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{
+			"email":    "alice@example.com",
+			"password": "pa55word1234",
+		}, "")
+		if status != http.StatusCreated {
+			t.Fatalf("logging in: got status %d, %v", status, resp)
+		}
+		return field[string](t, resp, "access_token")
+	}
+
+	old := login()
+
+	status, resp := ts.do(t, http.MethodDelete, "/v1/tokens/authentication/all", nil, old)
+	if status != http.StatusOK {
+		t.Fatalf("logging out everywhere: got status %d, %v", status, resp)
+	}
+
+	// very likely issued within the same second as the revocation
+	current := login()
+
+	status, _ = ts.do(t, http.MethodGet, "/v1/healthcheck", nil, old)
+	if status != http.StatusUnauthorized {
+		t.Errorf("token from before: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	status, resp = ts.do(t, http.MethodGet, "/v1/healthcheck", nil, current)
+	if status != http.StatusOK {
+		t.Errorf("token from after: got status %d, want %d: %v", status, http.StatusOK, resp)
+	}
+}
+
+func TestIPBackoffThreshold(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.throttle.ipBackoffThreshold = 3
+	app.config.throttle.backoffBase = time.Minute
+	app.config.throttle.backoffMax = time.Hour
+	ts := newTestServer(t, app.routes())
+
+	ts.activate(t, ts.register(t, "Alice", "alice@example.com", "pa55word1234"))
+
+	login := func(email string) int {
+		status, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{
+			"email":    email,
+			"password": "pa55word1234",
+		}, "")
+		return status
+	}
+
+	// mistyped emails from a shared address don't hold anyone up at first
+	for i := range 2 {
+		if status := login("nobody@example.com"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got status %d, want %d", i+1, status, http.StatusUnauthorized)
+		}
+	}
+
+	if status := login("alice@example.com"); status != http.StatusCreated {
+		t.Fatalf("below the threshold: got status %d, want %d", status, http.StatusCreated)
+	}
+
+	if status := login("nobody@example.com"); status != http.StatusUnauthorized {
+		t.Fatalf("third failure: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	if status := login("alice@example.com"); status != http.StatusTooManyRequests {
+		t.Fatalf("at the threshold: got status %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestUpdateCurrentUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	ts.activate(t, ts.register(t, "Alice", "alice@example.com", "pa55word1234"))
+
+	status, resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{
+		"email":    "alice@example.com",
+		"password": "pa55word1234",
+	}, "")
+	if status != http.StatusCreated {
+		t.Fatalf("logging in: got status %d, %v", status, resp)
+	}
+
+	token := field[string](t, resp, "access_token")
+
+	tests := []struct {
+		name       string
+		body       map[string]string
+		wantStatus int
+	}{
+		{"new name", map[string]string{"name": "Alicia"}, http.StatusOK},
+		{"same email", map[string]string{"email": "ALICE@example.com"}, http.StatusOK},
+		{"new email without password", map[string]string{"email": "alicia@example.com"}, http.StatusUnprocessableEntity},
+		{"new email with wrong password", map[string]string{"email": "alicia@example.com", "current_password": "wrong-password"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := ts.do(t, http.MethodPatch, "/v1/users/me", tt.body, token)
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+		})
+	}
+
+	user, err := app.models.Users.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Username != "Alicia" {
+		t.Errorf("got name %q, want %q", user.Username, "Alicia")
+	}
+}
@@ -6,19 +6,25 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 type Envelope map[string]any
 
+// errEmptyBody is returned by ReadJSON when the request has no body at all,
+// handlers where the body is optional check for it
+var errEmptyBody = errors.New("body must not be empty")
+
 func (app *application) ParseParams(w http.ResponseWriter, r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
 	if err != nil || id < 1 {
-		return 0, err
+		return 0, errors.New("invalid id parameter")
 	}
 
 	return id, nil
@@ -71,7 +77,7 @@ func (app *application) ReadJSON(w http.ResponseWriter, r *http.Request, dst any
 			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshallTypeError.Offset)
 
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return errEmptyBody
 
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -89,3 +95,73 @@ func (app *application) ReadJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	return nil
 }
+
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// readInt records a problem with the parameter in errs instead of failing, so
+// every bad parameter can be reported at once
+func (app *application) readInt(qs url.Values, key string, defaultValue int, errs map[string]string) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		errs[key] = "must be an integer value"
+		return defaultValue
+	}
+
+	return i
+}
+
+// readTime accepts an RFC 3339 timestamp or a plain date. endOfDay moves a
+// plain date to the start of the next day, for exclusive upper bounds
+func (app *application) readTime(qs url.Values, key string, endOfDay bool, errs map[string]string) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return &t
+	}
+
+	t, err = time.Parse(time.DateOnly, s)
+	if err != nil {
+		errs[key] = "must be a date (2006-01-02) or an RFC 3339 timestamp"
+		return nil
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return &t
+}
+
+// runs fn in its own goroutine, a panic in fn is logged instead of crashing the
+// server. Shutdown waits for fn to return
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.log.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJSONEmptyBody(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		body      string
+		chunked   bool
+		wantEmpty bool
+		wantErr   bool
+	}{
+		{name: "no body", body: "", wantEmpty: true, wantErr: true},
+		{name: "no body, chunked", body: "", chunked: true, wantEmpty: true, wantErr: true},
+		{name: "whitespace", body: " \n", wantEmpty: true, wantErr: true},
+		{name: "empty object", body: "{}"},
+		{name: "empty object, chunked", body: "{}", chunked: true},
+		{name: "badly formed", body: "{", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				// the length isn't known up front, like with a chunked request
+				r.ContentLength = -1
+			}
+
+			var input struct {
+				Amount *string `json:"amount"`
+			}
+
+			err := app.ReadJSON(httptest.NewRecorder(), r, &input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if errors.Is(err, errEmptyBody) != tt.wantEmpty {
+				t.Errorf("got %v, want an empty body error %v", err, tt.wantEmpty)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultHoldTTL = 7 * 24 * time.Hour
+	maxHoldTTL     = 30 * 24 * time.Hour
+)
+
+func (app *application) listBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	balances, err := app.models.Ledger.BalancesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"balances": balances}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createHoldHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID      int64      `json:"user_id"`
+		Amount      data.Money `json:"amount"`
+		Description string     `json:"description"`
+		ExpiresIn   string     `json:"expires_in"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ttl := defaultHoldTTL
+	if input.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(input.ExpiresIn)
+		if err != nil || ttl <= 0 || ttl > maxHoldTTL {
+			app.failedValidationResponse(w, r, map[string]string{"expires_in": "must be a duration like 72h, at most 720h"})
+			return
+		}
+	}
+
+	hold := &data.Hold{
+		UserID:      input.UserID,
+		Amount:      input.Amount,
+		Description: input.Description,
+		ExpiresAt:   time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	err = hold.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	_, err = app.models.Users.Get(r.Context(), hold.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"user_id": "no user with this id"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Holds.Insert(r.Context(), hold)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "holds.create", hold.UserID, map[string]any{"hold_id": hold.ID, "amount": hold.Amount})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInsufficientFunds):
+			app.failedValidationResponse(w, r, map[string]string{"amount": "insufficient funds"})
+		case errors.Is(err, data.ErrAccountNotActive):
+			app.failedValidationResponse(w, r, map[string]string{"account": "the account is frozen or closed"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"hold": hold}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// captureHoldHandler captures the given amount, or everything left of the
+// hold when no amount is sent
+func (app *application) captureHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.ParseParams(w, r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Amount *data.Money `json:"amount"`
+	}
+
+	// the body is optional, chunked requests don't say up front whether there is one
+	err = app.ReadJSON(w, r, &input)
+	if err != nil && !errors.Is(err, errEmptyBody) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hold, err := app.models.Holds.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	amount := hold.Remaining()
+	if input.Amount != nil {
+		amount = *input.Amount
+	}
+
+	if err := data.PositiveMoney.Validate(amount); err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"amount": err.Error()})
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		var err error
+
+		hold, err = tx.Holds.Capture(r.Context(), id, amount)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "holds.capture", hold.UserID, map[string]any{"hold_id": hold.ID, "amount": amount})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrHoldNotActive), errors.Is(err, data.ErrCaptureTooMuch), errors.Is(err, data.ErrCurrencyMismatch):
+			app.failedValidationResponse(w, r, map[string]string{"amount": err.Error()})
+		case errors.Is(err, data.ErrAccountNotActive):
+			app.failedValidationResponse(w, r, map[string]string{"account": "the account is frozen or closed"})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"hold": hold}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) releaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.ParseParams(w, r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	var hold *data.Hold
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		var err error
+
+		hold, err = tx.Holds.Release(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "holds.release", hold.UserID, map[string]any{"hold_id": hold.ID})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		case errors.Is(err, data.ErrHoldNotActive):
+			app.failedValidationResponse(w, r, map[string]string{"status": err.Error()})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"hold": hold}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5"
+)
+
+func (app *application) createInterestProductHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		AnnualRate  string `json:"annual_rate"`
+		DayCount    string `json:"day_count"`
+		Compounding string `json:"compounding"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	product := &data.InterestProduct{
+		Name:        input.Name,
+		DayCount:    input.DayCount,
+		Compounding: input.Compounding,
+	}
+
+	product.AnnualRate, err = data.ParseRate(input.AnnualRate)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"annual_rate": err.Error()})
+		return
+	}
+
+	err = product.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Interest.InsertProduct(r.Context(), product)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "interest.products.create", 0, map[string]any{"product_id": product.ID, "annual_rate": product.AnnualRate.String()})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateInterestProduct):
+			app.failedValidationResponse(w, r, map[string]string{"name": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"interest_product": product}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listInterestProductsHandler(w http.ResponseWriter, r *http.Request) {
+	products, err := app.models.Interest.GetProducts(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"interest_products": products}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// attaches an interest product to the user's balance in one currency
+func (app *application) attachInterestProductHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.targetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Currency  string `json:"currency"`
+		ProductID int64  `json:"product_id"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.Currency = strings.ToUpper(input.Currency)
+
+	err = validation.Errors{
+		"currency":   validation.Validate(input.Currency, validation.Required, is.CurrencyCode),
+		"product_id": validation.Validate(input.ProductID, validation.Required, validation.Min(int64(1))),
+	}.Filter()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Interest.Attach(r.Context(), user.ID, input.Currency, input.ProductID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, tx, "interest.attach", user.ID, map[string]any{"currency": input.Currency, "product_id": input.ProductID})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"product_id": "no interest product with this id"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"message": "interest product attached"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runInterest is the `interest` subcommand, meant to be run from cron:
+//
+//	api interest accrue [-date 2024-01-31]
+//	api interest post [-month 2024-01]
+//
+// accrue defaults to yesterday and post to last month. Both can be run again
+// for the same day or month, balances that are already done are skipped
+func (app *application) runInterest(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: interest accrue|post [flags]")
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	switch args[0] {
+	case "accrue":
+		fs := flag.NewFlagSet("interest accrue", flag.ContinueOnError)
+		date := fs.String("date", today.AddDate(0, 0, -1).Format(time.DateOnly), "day to accrue interest for")
+
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+
+		day, err := time.Parse(time.DateOnly, *date)
+		if err != nil {
+			return fmt.Errorf("invalid -date: %w", err)
+		}
+		if !day.Before(today) {
+			return errors.New("can only accrue interest for days that have ended")
+		}
+
+		n, err := app.models.Interest.Accrue(ctx, day)
+		if err != nil {
+			return err
+		}
+
+		app.log.Info("interest accrued", "day", day.Format(time.DateOnly), "balances", n)
+
+	case "post":
+		fs := flag.NewFlagSet("interest post", flag.ContinueOnError)
+		lastMonth := time.Date(today.Year(), today.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		monthFlag := fs.String("month", lastMonth.Format("2006-01"), "month to post interest for")
+
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+
+		month, err := time.Parse("2006-01", *monthFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -month: %w", err)
+		}
+
+		end := month.AddDate(0, 1, 0)
+		if end.After(today) {
+			return errors.New("can only post interest for months that have ended")
+		}
+
+		// catch up on any day the daily job missed before paying out
+		for day := month; day.Before(end); day = day.AddDate(0, 0, 1) {
+			_, err := app.models.Interest.Accrue(ctx, day)
+			if err != nil {
+				return err
+			}
+		}
+
+		n, err := app.models.Interest.Post(ctx, month)
+		if err != nil {
+			return err
+		}
+
+		app.log.Info("interest posted", "month", month.Format("2006-01"), "balances", n)
+
+	default:
+		return fmt.Errorf("unknown interest command %q", args[0])
+	}
+
+	return nil
+}
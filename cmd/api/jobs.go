@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"context"
+	"errors"
+	"time"
+)
+
+// syncRevocations periodically reloads the revocation cache so that tokens
+// revoked through another instance of the API are rejected here as well
+func (app *application) syncRevocations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.models.Revocations.Load(ctx)
+			if err != nil {
+				app.log.Error("couldn't reload token revocations", "error", err.Error())
+			}
+		}
+	}
+}
+
+// expireHolds gives money reserved by expired holds back to the users
+func (app *application) expireHolds(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.models.Holds.ExpireDue(ctx)
+			if err != nil {
+				app.log.Error("couldn't expire holds", "error", err.Error())
+			}
+			if n > 0 {
+				app.log.Info("expired holds", "count", n)
+			}
+		}
+	}
+}
+
+// runStandingOrders executes standing orders as they fall due. Every instance
+// of the API runs it, the orders themselves are locked so each run happens once
+func (app *application) runStandingOrders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.runDueStandingOrders(ctx, app.models.StandingOrders)
+		}
+	}
+}
+
+// standingOrderRunner is data.StandingOrderModel as far as the scheduler is
+// concerned
+type standingOrderRunner interface {
+	RunNext(ctx context.Context, now time.Time) (*data.StandingOrder, *data.StandingOrderRun, error)
+}
+
+// runDueStandingOrders works through every due order. An order whose transfer
+// failed has been put off by RunNext, so the loop carries on with the others
+func (app *application) runDueStandingOrders(ctx context.Context, orders standingOrderRunner) {
+	for ctx.Err() == nil {
+		order, run, err := orders.RunNext(ctx, time.Now())
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return
+		case errors.Is(err, data.ErrStandingOrderRetry):
+			app.log.Warn("standing order will be retried", "standing_order_id", order.ID, "retry_at", order.RetryAt, "error", err.Error())
+			continue
+		case err != nil:
+			app.log.Error("couldn't run standing order", "error", err.Error())
+			return
+		}
+
+		if !run.Succeeded {
+			app.log.Warn("standing order failed", "standing_order_id", order.ID, "error", run.Error)
+			app.notifyFailedStandingOrder(ctx, order, run)
+		}
+	}
+}
+
+func (app *application) notifyFailedStandingOrder(ctx context.Context, order *data.StandingOrder, run *data.StandingOrderRun) {
+	user, err := app.models.Users.Get(ctx, order.UserID)
+	if err != nil {
+		app.log.Error(err.Error())
+		return
+	}
+
+	recipient, err := app.models.Users.Get(ctx, order.ToUserID)
+	if err != nil {
+		app.log.Error(err.Error())
+		return
+	}
+
+	data := map[string]any{
+		"amount":       order.Amount.String(),
+		"recipient":    recipient.Username,
+		"scheduledFor": run.ScheduledFor.UTC().Format(time.RFC1123),
+		"reason":       run.Error,
+		"nextRunAt":    "",
+	}
+	if order.NextRunAt != nil {
+		data["nextRunAt"] = order.NextRunAt.UTC().Format(time.RFC1123)
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(user.Email, "standing_order_failed.tmpl", data)
+		if err != nil {
+			app.log.Error(err.Error())
+		}
+	})
+}
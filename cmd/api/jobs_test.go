@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeStandingOrders hands out due orders in order, transfers for the ids in
+// failing fail the way a broken order would
+type fakeStandingOrders struct {
+	due     []int64
+	failing map[int64]bool
+	ran     []int64
+}
+
+func (f *fakeStandingOrders) RunNext(ctx context.Context, now time.Time) (*data.StandingOrder, *data.StandingOrderRun, error) {
+	if len(f.due) == 0 {
+		return nil, nil, data.ErrRecordNotFound
+	}
+
+	id := f.due[0]
+	f.due = f.due[1:]
+
+	order := &data.StandingOrder{ID: id, NextRunAt: &now}
+
+	if f.failing[id] {
+		retryAt := now.Add(5 * time.Minute)
+		order.RetryAt = &retryAt
+		return order, nil, fmt.Errorf("%w: %w", data.ErrStandingOrderRetry, errors.New("account frozen"))
+	}
+
+	f.ran = append(f.ran, id)
+	return order, &data.StandingOrderRun{StandingOrderID: id, ScheduledFor: now, Succeeded: true}, nil
+}
+
+func TestRunDueStandingOrdersSkipsFailingOrder(t *testing.T) {
+	app := newTestApplication(t)
+
+	orders := &fakeStandingOrders{
+		due:     []int64{1, 2, 3},
+		failing: map[int64]bool{1: true},
+	}
+
+	app.runDueStandingOrders(context.Background(), orders)
+
+	if len(orders.due) != 0 {
+		t.Errorf("orders left unrun: %v", orders.due)
+	}
+
+	if fmt.Sprint(orders.ran) != "[2 3]" {
+		t.Errorf("ran %v, want [2 3]", orders.ran)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"bankapi/internal/totp"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	totpIssuer  = "bankapi"
+	mfaTokenTTL = 5 * time.Minute
+)
+
+// users with two-factor authentication get this token instead of real
+// credentials after entering their password, it can only be exchanged at
+// /v1/tokens/mfa together with a valid code
+func (app *application) writeMFAToken(w http.ResponseWriter, r *http.Request, userID int64, scope string) {
+	token, err := app.models.Tokens.New(r.Context(), userID, mfaTokenTTL, scope)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"mfa_required": true, "mfa_token": token}, nil, http.StatusAccepted)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// first step of the enrollment, the secret isn't used for logins until the
+// user proves their authenticator app works by confirming a code
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if user.TOTPEnabled {
+		app.failedValidationResponse(w, r, map[string]string{"totp": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := Envelope{
+		"secret":           totp.EncodeSecret(secret),
+		"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	}
+
+	err = app.WriteJSON(w, r, env, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	switch {
+	case user.TOTPEnabled:
+		app.failedValidationResponse(w, r, map[string]string{"totp": "two-factor authentication is already enabled"})
+		return
+	case user.TOTPSecret == nil:
+		app.failedValidationResponse(w, r, map[string]string{"totp": "two-factor authentication enrollment hasn't been started"})
+		return
+	}
+
+	if !user.VerifyTOTP(input.Code) {
+		app.failedValidationResponse(w, r, map[string]string{"code": "invalid code"})
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes(10)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.TOTPEnabled = true
+
+	// two-factor authentication is never enabled without recovery codes
+	err = app.models.WithTx(r.Context(), pgx.ReadCommitted, func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Users.SetRecoveryCodes(r.Context(), user.ID, codes)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the recovery codes are only stored hashed, this is the only time they are shown
+	err = app.WriteJSON(w, r, Envelope{"user": user, "recovery_codes": codes}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	token := &data.Token{
+		Token: input.MFAToken,
+	}
+
+	if err := token.Validate(); err != nil {
+		app.invalidAuthenticationToken(w, r)
+		return
+	}
+
+	scope := data.ScopeMFA
+
+	user, err := app.models.Users.GetForToken(r.Context(), scope, input.MFAToken)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		scope = data.ScopeMFAAuthentication
+		user, err = app.models.Users.GetForToken(r.Context(), scope, input.MFAToken)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationToken(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.userThrottled(w, r, user.ID) {
+		return
+	}
+
+	switch {
+	case input.Code != "":
+		if !user.VerifyTOTP(input.Code) {
+			app.loginFailed(w, r, user)
+			return
+		}
+
+		// stores the used time step, a conflict means the same code was
+		// redeemed by a concurrent request
+		err = app.models.Users.Update(r.Context(), user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.invalidCredentialResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+	case input.RecoveryCode != "":
+		ok, err := app.models.Users.UseRecoveryCode(r.Context(), user.ID, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.loginFailed(w, r, user)
+			return
+		}
+
+	default:
+		app.failedValidationResponse(w, r, map[string]string{"code": "a code or a recovery code must be provided"})
+		return
+	}
+
+	err = app.models.Tokens.DeleteForUser(r.Context(), scope, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginAttempts.ClearForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if scope == data.ScopeMFAAuthentication {
+		app.writeAuthenticationToken(w, r, user.ID)
+		return
+	}
+
+	app.writeJWTtokens(w, r, user.ID)
+}
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -48,7 +47,7 @@ func (app *application) authenicate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, authenticationtoken)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, authenticationtoken)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -85,18 +84,18 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 		authenticationtoken := headerPart[1]
 
 		// this token should be the access token
-		token, err := jwt.ParseWithClaims(authenticationtoken, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(SecretKey), nil //using the SecretKey which was generated in th Login function
-		})
+		token, err := jwt.ParseWithClaims(authenticationtoken, &jwtClaims{}, app.keys.Keyfunc)
 
 		if err != nil {
 			app.invalidAuthenticationToken(w, r)
 			return
 		}
 
-		claims := token.Claims.(*jwt.StandardClaims)
-		if time.Unix(claims.ExpiresAt, 0).Before(time.Now()) {
-			app.refreshJWTtoken(w, r)
+		// expired access tokens are rejected by ParseWithClaims above, clients
+		// have to exchange their refresh token at /v1/tokens/refresh
+		claims := token.Claims.(*jwtClaims)
+		if claims.Id == "" {
+			app.invalidAuthenticationToken(w, r)
 			return
 		}
 
@@ -106,7 +105,12 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.Get(userID)
+		if app.models.Revocations.IsRevoked(claims.Id, userID, claims.issuedAt()) {
+			app.invalidAuthenticationToken(w, r)
+			return
+		}
+
+		user, err := app.models.Users.Get(r.Context(), userID)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -118,6 +122,7 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 		}
 
 		r = app.contextSetUser(r, user)
+		r = app.contextSetClaims(r, claims)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -153,7 +158,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// runMigrate is the `migrate` subcommand:
+//
+//	api migrate up
+//	api migrate down N
+//	api migrate status
+//	api migrate force VERSION
+//
+// force is for cleaning up after a migration failed half way, it sets the
+// version (-1 for none) without running anything
+func (app *application) runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down N|status|force VERSION")
+	}
+
+	switch args[0] {
+	case "up":
+		return app.migrateUp(ctx)
+
+	case "down":
+		if len(args) != 2 {
+			return errors.New("usage: migrate down N")
+		}
+
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errors.New("N must be a positive number of migrations")
+		}
+
+		reverted, err := app.migrator.Down(ctx, n)
+		for _, m := range reverted {
+			app.log.Info("reverted migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(reverted) == 0 {
+			app.log.Info("no migrations to revert")
+		}
+
+	case "status":
+		version, dirty, err := app.migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, m := range app.migrator.Migrations {
+			state := "pending"
+			switch {
+			case m.Version == version && dirty:
+				state = "dirty"
+			case m.Version <= version:
+				state = "applied"
+			}
+			fmt.Fprintf(tw, "%06d\t%s\t%s\n", m.Version, m.Name, state)
+		}
+
+		err = tw.Flush()
+		if err != nil {
+			return err
+		}
+
+		if version == -1 {
+			fmt.Println("\nno migrations applied")
+		} else {
+			fmt.Printf("\nversion %d, dirty %t\n", version, dirty)
+		}
+
+	case "force":
+		if len(args) != 2 {
+			return errors.New("usage: migrate force VERSION")
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("VERSION must be a number")
+		}
+
+		err = app.migrator.Force(ctx, version)
+		if err != nil {
+			return err
+		}
+
+		app.log.Info("forced migration version", "version", version)
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	return nil
+}
+
+// migrateUp applies any pending migrations, used by `migrate up` and
+// -migrate-on-start
+func (app *application) migrateUp(ctx context.Context) error {
+	applied, err := app.migrator.Up(ctx)
+	for _, m := range applied {
+		app.log.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		app.log.Info("database schema is up to date")
+	}
+
+	return nil
+}
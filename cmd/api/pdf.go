@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	pdfPageWidth    = 612 // US letter in points
+	pdfPageHeight   = 792
+	pdfMargin       = 50
+	pdfFontSize     = 9
+	pdfLeading      = 12
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+)
+
+// object numbers fixed up front, pages are numbered from pdfFirstPageObject
+const (
+	pdfCatalogObject = 1
+	pdfPagesObject   = 2
+	pdfFontObject    = 3
+
+	pdfFirstPageObject = 4
+)
+
+// pdfStatement writes a plain PDF 1.4 by hand, one page at a time. Only the
+// page being filled is buffered; the page tree and cross-reference table,
+// which need every page, come at the end of the file where PDF allows them
+type pdfStatement struct {
+	w       *countingWriter
+	s       *statement
+	offsets map[int]int64
+	pages   []int
+	page    bytes.Buffer
+	lines   int
+	err     error
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newPDFStatement(w io.Writer) *pdfStatement {
+	return &pdfStatement{
+		w:       &countingWriter{w: w},
+		offsets: make(map[int]int64),
+	}
+}
+
+func (p *pdfStatement) object(id int, body string) {
+	if p.err != nil {
+		return
+	}
+
+	p.offsets[id] = p.w.n
+	_, p.err = fmt.Fprintf(p.w, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+// pdfEscape makes s safe inside a PDF string literal. The built-in fonts
+// only cover Latin-1, anything else is replaced
+func pdfEscape(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+func (p *pdfStatement) text(format string, args ...any) {
+	if p.lines == pdfLinesPerPage {
+		p.flushPage()
+		p.heading()
+	}
+
+	fmt.Fprintf(&p.page, "(%s) Tj T*\n", pdfEscape(fmt.Sprintf(format, args...)))
+	p.lines++
+}
+
+func (p *pdfStatement) heading() {
+	p.text("Statement for %s, %s %s to %s", p.s.User.Username, p.s.Currency,
+		p.s.From.Format(time.DateOnly), p.s.lastDay().Format(time.DateOnly))
+	p.text("")
+	p.text("%-20s %-30s %-18s %14s %14s", "Date", "Description", "Counterparty", "Amount", "Balance")
+}
+
+// flushPage writes the buffered page as a content stream and a page object
+func (p *pdfStatement) flushPage() {
+	contents := fmt.Sprintf("BT\n/F1 %d Tf\n%d TL\n%d %d Td\n%sET",
+		pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin, p.page.String())
+
+	id := pdfFirstPageObject + 2*len(p.pages)
+	p.object(id, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(contents), contents))
+	p.object(id+1, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+		pdfPagesObject, pdfPageWidth, pdfPageHeight, pdfFontObject, id))
+
+	p.pages = append(p.pages, id+1)
+	p.page.Reset()
+	p.lines = 0
+}
+
+// truncate cuts s to n characters, marking the cut with a ~. Characters, not
+// bytes, since that's what the column widths count
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:n-1]) + "~"
+}
+
+func (p *pdfStatement) begin(s *statement) error {
+	p.s = s
+
+	if p.err == nil {
+		// the binary comment tells transfer programs the file isn't text
+		_, p.err = io.WriteString(p.w, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	}
+	p.object(pdfCatalogObject, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pdfPagesObject))
+	p.object(pdfFontObject, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	p.heading()
+	p.text("%-20s %-30s %-18s %14s %14s", s.From.Format(time.DateOnly), "Opening balance", "", "", s.Opening.Decimal())
+
+	return p.err
+}
+
+func (p *pdfStatement) line(t *data.Transaction, balance data.Money) error {
+	p.text("%-20s %-30s %-18s %14s %14s",
+		t.CreatedAt.UTC().Format("2006-01-02 15:04:05"),
+		truncate(t.Description, 30),
+		truncate(t.Counterparty, 18),
+		t.Signed().Decimal(),
+		balance.Decimal(),
+	)
+
+	return p.err
+}
+
+func (p *pdfStatement) end(closing data.Money) error {
+	p.text("")
+	p.text("%-20s %-30s %-18s %14s %14s", p.s.lastDay().Format(time.DateOnly), "Closing balance", "", "", closing.Decimal())
+	p.flushPage()
+
+	kids := make([]string, len(p.pages))
+	for i, id := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	p.object(pdfPagesObject, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+
+	if p.err != nil {
+		return p.err
+	}
+
+	// every entry of the cross-reference table is exactly 20 bytes
+	size := pdfFirstPageObject + 2*len(p.pages)
+	xref := p.w.n
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for id := 1; id < size; id++ {
+		fmt.Fprintf(&b, "%010d 00000 n \n", p.offsets[id])
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, pdfCatalogObject, xref)
+
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
@@ -10,9 +10,54 @@ func (app *application) routes() http.Handler {
 	mux := httprouter.New()
 
 	mux.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.requiredActivatedUser(app.healthcheck))
+	mux.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
 	mux.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHanlder)
 	mux.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createJWTtoken)
+	mux.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requiredAuthenicatedUser(app.deleteAuthenticationTokenHandler))
+	mux.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requiredAuthenicatedUser(app.deleteAllAuthenticationTokensHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshJWTtoken)
+	mux.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFATokenHandler)
 	mux.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	mux.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	mux.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	mux.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requiredActivatedUser(app.updateCurrentUserHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requiredActivatedUser(app.createTOTPHandler))
+	mux.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.requiredActivatedUser(app.confirmTOTPHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/users/me/transactions", app.requiredActivatedUser(app.listTransactionsHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/users/me/statements", app.requiredActivatedUser(app.showStatementHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/users/me/balances", app.requiredActivatedUser(app.listBalancesHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	mux.HandlerFunc(http.MethodGet, "/v1/accounts", app.requirePermission("accounts:read", app.listAccountsHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/accounts", app.requirePermission("accounts:write", app.createAccountHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/accounts/:id", app.requirePermission("accounts:read", app.showAccountHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/accounts/:id/close", app.requirePermission("accounts:write", app.closeAccountHandler))
+
+	mux.HandlerFunc(http.MethodPost, "/v1/transfers", app.requiredActivatedUser(app.createTransferHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/fx/quotes", app.requiredActivatedUser(app.createFXQuoteHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/fx/conversions", app.requiredActivatedUser(app.createFXConversionHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/standing-orders", app.requiredActivatedUser(app.listStandingOrdersHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/standing-orders", app.requiredActivatedUser(app.createStandingOrderHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/standing-orders/:id", app.requiredActivatedUser(app.showStandingOrderHandler))
+	mux.HandlerFunc(http.MethodDelete, "/v1/standing-orders/:id", app.requiredActivatedUser(app.cancelStandingOrderHandler))
+
+	mux.HandlerFunc(http.MethodPost, "/v1/holds", app.requirePermission("holds:write", app.createHoldHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/holds/:id/capture", app.requirePermission("holds:write", app.captureHoldHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/holds/:id/release", app.requirePermission("holds:write", app.releaseHoldHandler))
+
+	mux.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("permissions:read", app.listPermissionsHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/admin/permissions", app.requirePermission("permissions:write", app.createPermissionHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("permissions:read", app.listRolesHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:read", app.showUserPermissionsHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:write", app.grantUserPermissionsHandler))
+	mux.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("permissions:write", app.revokeUserPermissionHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("permissions:write", app.assignUserRolesHandler))
+	mux.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("permissions:write", app.removeUserRoleHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("audit:read", app.listAuditLogHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/admin/fx/rates", app.requirePermission("fx:write", app.uploadFXRatesHandler))
+	mux.HandlerFunc(http.MethodGet, "/v1/admin/interest-products", app.requirePermission("interest:write", app.listInterestProductsHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/admin/interest-products", app.requirePermission("interest:write", app.createInterestProductHandler))
+	mux.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/interest", app.requirePermission("interest:write", app.attachInterestProductHandler))
 
 	return app.logRequest(app.enableCors(app.authenticateJWT(mux)))
 }
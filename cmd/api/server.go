@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs the HTTP server and the background jobs until SIGINT or SIGTERM.
+// Shutting down stops new connections, lets in-flight requests finish, stops
+// the jobs and waits for every background goroutine, all within
+// -shutdown-timeout
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:     fmt.Sprintf(":%d", app.config.port),
+		ErrorLog: slog.NewLogLogger(app.log.Handler(), slog.LevelError),
+		Handler:  app.routes(),
+	}
+
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	app.background(func() { app.syncRevocations(jobs, 30*time.Second) })
+	app.background(func() { app.expireHolds(jobs, time.Minute) })
+	app.background(func() { app.runStandingOrders(jobs, 30*time.Second) })
+
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.log.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.log.Info("stopped accepting requests, waiting for background tasks")
+		stopJobs()
+
+		done := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			app.log.Info("background tasks finished")
+			shutdownError <- nil
+		case <-ctx.Done():
+			shutdownError <- errors.New("background tasks didn't finish before the shutdown timeout")
+		}
+	}()
+
+	app.log.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.log.Info("stopped server", "addr", srv.Addr)
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func (app *application) createStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ToUserID    int64      `json:"to_user_id"`
+		Amount      data.Money `json:"amount"`
+		Description string     `json:"description"`
+		Schedule    string     `json:"schedule"`
+		EndAt       *time.Time `json:"end_at"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	order := &data.StandingOrder{
+		UserID:      user.ID,
+		ToUserID:    input.ToUserID,
+		Amount:      input.Amount,
+		Description: input.Description,
+		Schedule:    input.Schedule,
+		EndAt:       input.EndAt,
+	}
+
+	err = order.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	_, err = app.models.Users.Get(r.Context(), order.ToUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"to_user_id": "no user with this id"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.StandingOrders.Insert(r.Context(), order)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/standing-orders/%d", order.ID))
+
+	err = app.WriteJSON(w, r, Envelope{"standing_order": order}, headers, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listStandingOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	orders, err := app.models.StandingOrders.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"standing_orders": orders}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ownStandingOrder works like ownAccount, other users' orders are not found
+func (app *application) ownStandingOrder(w http.ResponseWriter, r *http.Request) *data.StandingOrder {
+	id, err := app.ParseParams(w, r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return nil
+	}
+
+	order, err := app.models.StandingOrders.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if order.UserID != app.contextGetUser(r).ID {
+		app.notFoundErrorResponse(w, r)
+		return nil
+	}
+
+	return order
+}
+
+func (app *application) showStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.ownStandingOrder(w, r)
+	if order == nil {
+		return
+	}
+
+	err := app.WriteJSON(w, r, Envelope{"standing_order": order}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.ownStandingOrder(w, r)
+	if order == nil {
+		return
+	}
+
+	if order.Status != data.StandingOrderStatusActive {
+		app.failedValidationResponse(w, r, map[string]string{"status": "the standing order is already " + order.Status})
+		return
+	}
+
+	err := app.models.StandingOrders.Cancel(r.Context(), order)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"standing_order": order}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// statement formats and the media types that ask for them in Accept
+var statementFormats = map[string]string{
+	"csv": "text/csv",
+	"ofx": "application/x-ofx",
+	"pdf": "application/pdf",
+}
+
+type statement struct {
+	User     *data.Users
+	Currency string
+	From     time.Time
+	To       time.Time
+	Opening  data.Money
+}
+
+// statementWriter renders a statement line by line, so nothing but the line
+// being written has to be held in memory
+type statementWriter interface {
+	begin(s *statement) error
+	line(t *data.Transaction, balance data.Money) error
+	end(closing data.Money) error
+}
+
+func (app *application) showStatementHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	from := app.readTime(qs, "from", false, errs)
+	to := app.readTime(qs, "to", true, errs)
+	currency := strings.ToUpper(qs.Get("currency"))
+
+	format := qs.Get("format")
+	if format == "" {
+		format = negotiateStatementFormat(r.Header.Get("Accept"))
+	}
+
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	err := validation.Errors{
+		"from":     validation.Validate(from, validation.NotNil),
+		"to":       validation.Validate(to, validation.NotNil),
+		"currency": validation.Validate(currency, validation.Required, is.CurrencyCode),
+		"format":   validation.Validate(format, validation.In("csv", "ofx", "pdf")),
+	}.Filter()
+	if err == nil && !to.After(*from) {
+		err = validation.Errors{"to": validation.NewError("validation_to_after_from", "must be after from")}
+	}
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	opening, err := app.models.Transactions.OpeningBalance(r.Context(), user.ID, currency, *from)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	s := &statement{User: user, Currency: currency, From: *from, To: *to, Opening: opening}
+
+	filename := fmt.Sprintf("statement-%s-%s-%s.%s", currency, from.Format(time.DateOnly), s.lastDay().Format(time.DateOnly), format)
+
+	w.Header().Set("Content-Type", statementFormats[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// from here on the status is sent, errors can only be logged and the
+	// body cut short
+	out := bufio.NewWriter(w)
+
+	var sw statementWriter
+	switch format {
+	case "ofx":
+		sw = &ofxStatement{w: out}
+	case "pdf":
+		sw = newPDFStatement(out)
+	default:
+		sw = &csvStatement{w: csv.NewWriter(out)}
+	}
+
+	err = app.writeStatement(r.Context(), s, sw, out, w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) writeStatement(ctx context.Context, s *statement, sw statementWriter, out *bufio.Writer, w http.ResponseWriter) error {
+	err := sw.begin(s)
+	if err != nil {
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+	balance := s.Opening
+	lines := 0
+
+	err = app.models.Transactions.Each(ctx, s.User.ID, s.Currency, s.From, s.To, func(t *data.Transaction) error {
+		next, err := balance.Add(t.Signed())
+		if err != nil {
+			return err
+		}
+		balance = next
+
+		err = sw.line(t, balance)
+		if err != nil {
+			return err
+		}
+
+		// push the body out to the client every so often instead of
+		// holding it until the end
+		lines++
+		if lines%100 == 0 && flusher != nil {
+			err = out.Flush()
+			if err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = sw.end(balance)
+	if err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+// the to parameter is exclusive, statements show the last day they cover
+func (s *statement) lastDay() time.Time {
+	return s.To.Add(-time.Second)
+}
+
+// negotiateStatementFormat picks the first format the Accept header asks
+// for, CSV if it doesn't ask for any of them
+func negotiateStatementFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		for format, t := range statementFormats {
+			if strings.EqualFold(mediaType, t) {
+				return format
+			}
+		}
+	}
+
+	return "csv"
+}
+
+type csvStatement struct {
+	w *csv.Writer
+}
+
+func (c *csvStatement) begin(s *statement) error {
+	c.w.Write([]string{"date", "id", "description", "counterparty", "amount", "currency", "balance"})
+	return c.w.Write([]string{s.From.Format(time.DateOnly), "", "opening balance", "", "", s.Currency, s.Opening.Decimal()})
+}
+
+func (c *csvStatement) line(t *data.Transaction, balance data.Money) error {
+	return c.w.Write([]string{
+		t.CreatedAt.Format(time.RFC3339),
+		strconv.FormatInt(t.ID, 10),
+		csvText(t.Description),
+		csvText(t.Counterparty),
+		t.Signed().Decimal(),
+		t.Amount.Currency(),
+		balance.Decimal(),
+	})
+}
+
+// csvText keeps spreadsheets from running text as a formula. Descriptions are
+// written by whoever sent the money, so one starting with = could be
+// =HYPERLINK(...) or worse; a leading ' makes the cell plain text
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (c *csvStatement) end(closing data.Money) error {
+	c.w.Write([]string{"", "", "closing balance", "", "", closing.Currency(), closing.Decimal()})
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ofxStatement writes an OFX 2.2 bank statement response
+type ofxStatement struct {
+	w   io.Writer
+	s   *statement
+	err error
+}
+
+const ofxTime = "20060102150405"
+
+func (o *ofxStatement) printf(format string, args ...any) {
+	if o.err == nil {
+		_, o.err = fmt.Fprintf(o.w, format, args...)
+	}
+}
+
+// ofxEscape cuts s down to the field's limit, which OFX counts in characters,
+// and escapes it for XML
+func ofxEscape(s string, limit int) string {
+	n := 0
+	for i := range s {
+		if n == limit {
+			s = s[:i]
+			break
+		}
+		n++
+	}
+
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (o *ofxStatement) begin(s *statement) error {
+	o.s = s
+	now := time.Now().UTC().Format(ofxTime)
+
+	o.printf("<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"no\"?>\n")
+	o.printf("<?OFX OFXHEADER=\"200\" VERSION=\"220\" SECURITY=\"NONE\" OLDFILEUID=\"NONE\" NEWFILEUID=\"NONE\"?>\n")
+	o.printf("<OFX>\n<SIGNONMSGSRSV1><SONRS>\n")
+	o.printf("<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n")
+	o.printf("<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE>\n", now)
+	o.printf("</SONRS></SIGNONMSGSRSV1>\n<BANKMSGSRSV1><STMTTRNRS>\n")
+	o.printf("<TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n")
+	o.printf("<STMTRS><CURDEF>%s</CURDEF>\n", s.Currency)
+	o.printf("<BANKACCTFROM><BANKID>bankapi</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n", s.User.ID)
+	o.printf("<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", s.From.UTC().Format(ofxTime), s.To.UTC().Format(ofxTime))
+
+	return o.err
+}
+
+func (o *ofxStatement) line(t *data.Transaction, balance data.Money) error {
+	trnType := "CREDIT"
+	if t.Direction == data.DirectionDebit {
+		trnType = "DEBIT"
+	}
+
+	o.printf("<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%d</FITID>",
+		trnType, t.CreatedAt.UTC().Format(ofxTime), t.Signed().Decimal(), t.ID)
+	if t.Counterparty != "" {
+		o.printf("<NAME>%s</NAME>", ofxEscape(t.Counterparty, 32))
+	}
+	if t.Description != "" {
+		o.printf("<MEMO>%s</MEMO>", ofxEscape(t.Description, 255))
+	}
+	o.printf("</STMTTRN>\n")
+
+	return o.err
+}
+
+func (o *ofxStatement) end(closing data.Money) error {
+	o.printf("</BANKTRANLIST>\n")
+	o.printf("<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n", closing.Decimal(), o.s.To.UTC().Format(ofxTime))
+	o.printf("</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n")
+
+	return o.err
+}
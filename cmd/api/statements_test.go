@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestOFXEscape(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"short", "coffee", 32, "coffee"},
+		{"cut", "abcdef", 3, "abc"},
+		{"multibyte", "café crème", 4, "café"},
+		{"cut after multibyte", "ééééé", 3, "ééé"},
+		{"exactly the limit", "日本語", 3, "日本語"},
+		{"escaped", "R&D <ltd>", 32, "R&amp;D &lt;ltd&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ofxEscape(tt.s, tt.limit)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q isn't valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"short", "coffee", 18, "coffee"},
+		{"exactly the width", "abcdef", 6, "abcdef"},
+		{"cut", "abcdefgh", 6, "abcde~"},
+		{"multibyte that fits", "éééééééééééééééééééé", 20, "éééééééééééééééééééé"},
+		{"multibyte cut", "café crème brûlée", 10, "café crèm~"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.s, tt.n)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q isn't valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", ""},
+		{"rent", "rent"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1+2", "'+1+2"},
+		{"-1+2", "'-1+2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+	}
+
+	for _, tt := range tests {
+		got := csvText(tt.s)
+		if got != tt.want {
+			t.Errorf("csvText(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"bankapi/internal/jwks"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestApplication returns an application backed by the in-memory models,
+// new users get the customer role like they do by default
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	keys, err := jwks.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.defaultRoles = []string{"customer"}
+	cfg.throttle.maxFailures = 5
+	cfg.throttle.ipMaxFailures = 50
+	cfg.throttle.ipBackoffThreshold = 10
+	cfg.throttle.window = 15 * time.Minute
+	cfg.throttle.lockout = 15 * time.Minute
+
+	return &application{
+		config: cfg,
+		log:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewMemoryModels(&data.Role{Name: "customer", Permissions: data.Permissions{"accounts:read", "accounts:write"}}),
+		keys:   keys,
+	}
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// do sends body as JSON, with token as bearer token unless it's empty, and
+// decodes the JSON response
+func (ts *testServer) do(t *testing.T, method, path string, body any, token string) (int, map[string]any) {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp map[string]any
+	if len(bytes.TrimSpace(b)) > 0 && bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		err = json.Unmarshal(b, &resp)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", b, err)
+		}
+	}
+
+	return res.StatusCode, resp
+}
+
+// register creates a user through the API and returns their activation token
+func (ts *testServer) register(t *testing.T, name, email, password string) string {
+	t.Helper()
+
+	status, resp := ts.do(t, http.MethodPost, "/v1/users", map[string]string{
+		"name":     name,
+		"email":    email,
+		"password": password,
+	}, "")
+	if status != http.StatusCreated {
+		t.Fatalf("registering %s: got status %d, %v", email, status, resp)
+	}
+
+	return field[string](t, resp, "activation_token", "token")
+}
+
+func (ts *testServer) activate(t *testing.T, token string) {
+	t.Helper()
+
+	status, resp := ts.do(t, http.MethodPut, "/v1/users/activated", map[string]string{"token": token}, "")
+	if status != http.StatusOK {
+		t.Fatalf("activating: got status %d, %v", status, resp)
+	}
+}
+
+// field digs through nested JSON objects by key
+func field[T any](t *testing.T, resp map[string]any, keys ...string) T {
+	t.Helper()
+
+	var v any = resp
+	for _, key := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			t.Fatalf("%v has no %q", v, key)
+		}
+		v = m[key]
+	}
+
+	value, ok := v.(T)
+	if !ok {
+		t.Fatalf("%v at %v is %T", v, keys, v)
+	}
+
+	return value
+}
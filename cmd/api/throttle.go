@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// checkCredentials looks up the user and checks their password while enforcing
+// the backoff and lockout rules for failed logins. On failure the response has
+// already been written and nil is returned.
+func (app *application) checkCredentials(w http.ResponseWriter, r *http.Request, email, password string) *data.Users {
+	ip := clientIP(r)
+
+	ipStats, err := app.models.LoginAttempts.ForIP(r.Context(), ip, time.Now().Add(-app.config.throttle.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	if ipStats.Failures >= app.config.throttle.ipMaxFailures {
+		app.rateLimitExceededResponse(w, r, time.Until(ipStats.LastFailure.Add(app.config.throttle.window)))
+		return nil
+	}
+
+	if wait := time.Until(ipStats.LastFailure.Add(app.ipBackoff(ipStats))); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return nil
+	}
+
+	user, err := app.models.Users.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.models.LoginAttempts.RecordFailure(r.Context(), nil, ip)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return nil
+			}
+			app.invalidCredentialResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if app.userThrottled(w, r, user.ID) {
+		return nil
+	}
+
+	matches, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	if !matches {
+		app.loginFailed(w, r, user)
+		return nil
+	}
+
+	// with two-factor authentication the login isn't complete yet, the failures
+	// are only cleared once the second factor has been checked as well
+	if !user.TOTPEnabled {
+		err = app.models.LoginAttempts.ClearForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil
+		}
+	}
+
+	return user
+}
+
+// ipBackoff is how long a client IP has to wait before its next attempt. Lots
+// of people can share an address, so the first few failures in the window,
+// mistyped emails included, are free and only count towards the IP lockout.
+// Each account's own backoff applies from its first failure regardless.
+func (app *application) ipBackoff(stats data.LoginStats) time.Duration {
+	over := stats.Failures - app.config.throttle.ipBackoffThreshold
+	if over < 0 {
+		return 0
+	}
+
+	stats.Failures = over + 1
+	return stats.Backoff(app.config.throttle.backoffBase, app.config.throttle.backoffMax)
+}
+
+// userThrottled writes a 423 or 429 response and returns true when the account
+// is locked or has to wait before the next attempt
+func (app *application) userThrottled(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if wait := time.Until(lockedUntil); wait > 0 {
+		app.accountLockedResponse(w, r, wait)
+		return true
+	}
+
+	stats, err := app.models.LoginAttempts.ForUser(r.Context(), userID, time.Now().Add(-app.config.throttle.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if wait := time.Until(stats.LastFailure.Add(stats.Backoff(app.config.throttle.backoffBase, app.config.throttle.backoffMax))); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return true
+	}
+
+	return false
+}
+
+// loginFailed records a wrong password or code and locks the account once
+// there have been too many of them
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, user *data.Users) {
+	ip := clientIP(r)
+
+	err := app.models.LoginAttempts.RecordFailure(r.Context(), &user.ID, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats, err := app.models.LoginAttempts.ForUser(r.Context(), user.ID, time.Now().Add(-app.config.throttle.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if stats.Failures < app.config.throttle.maxFailures {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	lockedUntil := time.Now().Add(app.config.throttle.lockout)
+
+	err = app.models.LoginAttempts.Lock(r.Context(), user.ID, lockedUntil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// counting starts over once the lock runs out
+	err = app.models.LoginAttempts.ClearForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.log.Warn("account locked after repeated failed logins", "user_id", user.ID, "ip", ip)
+
+	app.background(func() {
+		data := map[string]any{
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			"ip":          ip,
+		}
+
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.log.Error(err.Error())
+		}
+	})
+
+	app.accountLockedResponse(w, r, app.config.throttle.lockout)
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
@@ -2,14 +2,42 @@ package main
 
 import (
 	"bankapi/internal/data"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/golang-jwt/jwt"
 )
 
+const (
+	accessTokenTTL  = 2 * time.Minute
+	refreshTokenTTL = 24 * time.Hour
+)
+
+// jwtClaims ties every access token to the refresh token family it was issued
+// with, so logging out can end the whole session. iat only has whole seconds,
+// so a token issued in the same second as a logout from every session would
+// look revoked. iat_us carries the issue time in microseconds, which is what
+// revoke-all is compared against.
+type jwtClaims struct {
+	jwt.StandardClaims
+	SessionID     string `json:"sid,omitempty"`
+	IssuedAtMicro int64  `json:"iat_us,omitempty"`
+}
+
+func (c *jwtClaims) issuedAt() time.Time {
+	if c.IssuedAtMicro != 0 {
+		return time.UnixMicro(c.IssuedAtMicro)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
 func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
@@ -22,45 +50,82 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 		return
 	}
 
-	existingUser, err := app.models.Users.GetUserByEmail(input.Email)
+	existingUser := app.checkCredentials(w, r, input.Email, input.Password)
+	if existingUser == nil {
+		return
+	}
+
+	if existingUser.TOTPEnabled {
+		app.writeMFAToken(w, r, existingUser.ID, data.ScopeMFAAuthentication)
+		return
+	}
+
+	app.writeAuthenticationToken(w, r, existingUser.ID)
+}
+
+func (app *application) writeAuthenticationToken(w http.ResponseWriter, r *http.Request, userID int64) {
+	token, err := app.models.Tokens.New(r.Context(), userID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialResponse(w, r)
-			return
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	matches, err := existingUser.Password.Matches(input.Password)
+	err = app.WriteJSON(w, r, map[string]any{"authentication_token": token}, nil, http.StatusCreated)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createJWTtoken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	existingUser := app.checkCredentials(w, r, input.Email, input.Password)
+	if existingUser == nil {
 		return
 	}
 
-	if !matches {
-		app.invalidCredentialResponse(w, r)
+	if existingUser.TOTPEnabled {
+		app.writeMFAToken(w, r, existingUser.ID, data.ScopeMFA)
 		return
 	}
 
-	token, err := app.models.Tokens.New(existingUser.ID, 24*time.Hour, data.ScopeAuthentication)
+	app.writeJWTtokens(w, r, existingUser.ID)
+}
+
+// starts a new session: a refresh token family and the first access token for it
+func (app *application) writeJWTtokens(w http.ResponseWriter, r *http.Request, userID int64) {
+	refreshToken, err := app.models.Tokens.NewRefresh(r.Context(), userID, refreshTokenTTL)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.WriteJSON(w, r, map[string]any{"authentication_token": token}, nil, http.StatusCreated)
+	accessToken, err := app.newAccessToken(userID, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.WriteJSON(w, r, map[string]any{"access_token": accessToken, "refresh_token": refreshToken.Token}, nil, http.StatusCreated)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) createJWTtoken(w http.ResponseWriter, r *http.Request) {
+// refresh tokens are opaque, single use and stored hashed in the tokens table.
+// every successful refresh hands back a new access token and a new refresh token
+func (app *application) refreshJWTtoken(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	err := app.ReadJSON(w, r, &input)
@@ -69,66 +134,187 @@ func (app *application) createJWTtoken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingUser, err := app.models.Users.GetUserByEmail(input.Email)
+	token := &data.Token{
+		Token: input.RefreshToken,
+	}
+
+	if err := token.Validate(); err != nil {
+		app.invalidAuthenticationToken(w, r)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.Rotate(r.Context(), input.RefreshToken, refreshTokenTTL)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.log.Warn("refresh token reused, token family revoked", "remote_addr", r.RemoteAddr)
+			app.invalidAuthenticationToken(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialResponse(w, r)
-			return
+			app.invalidAuthenticationToken(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	matches, err := existingUser.Password.Matches(input.Password)
+	accessToken, err := app.newAccessToken(refreshToken.UserID, refreshToken.Family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if !matches {
-		app.invalidCredentialResponse(w, r)
-		return
+	err = app.WriteJSON(w, r, map[string]any{"access_token": accessToken, "refresh_token": refreshToken.Token}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) newAccessToken(userID int64, family []byte) (string, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return "", err
 	}
 
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Subject:   strconv.FormatInt(existingUser.ID, 10),
-		Issuer:    "jibeshshrestha",
-		IssuedAt:  jwt.TimeFunc().Unix(),
-		NotBefore: jwt.TimeFunc().Unix(),
-		ExpiresAt: jwt.TimeFunc().Add(2 * time.Minute).Unix(),
-		Audience:  "jibeshshrestha",
+	now := jwt.TimeFunc()
+
+	return app.keys.Sign(jwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(jti),
+			Subject:   strconv.FormatInt(userID, 10),
+			Issuer:    "jibeshshrestha",
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			Audience:  "jibeshshrestha",
+		},
+		SessionID:     hex.EncodeToString(family),
+		IssuedAtMicro: now.UnixMicro(),
 	})
+}
 
-	accessToken, err := claims.SignedString([]byte(SecretKey))
+// publishes the public keys so other services can verify our access tokens
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
 
+	err := app.WriteJSON(w, r, app.keys.Document(), headers, http.StatusOK)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logs out the current session: the access token used for this request is
+// revoked and its refresh token family is deleted
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	claims, ok := app.contextGetClaims(r)
+	if !ok {
+		app.invalidAuthenticationToken(w, r)
 		return
 	}
 
-	refreshClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Subject:   strconv.FormatInt(existingUser.ID, 10),
-		Issuer:    "jibeshshrestha",
-		IssuedAt:  jwt.TimeFunc().Unix(),
-		NotBefore: jwt.TimeFunc().Unix(),
-		ExpiresAt: jwt.TimeFunc().Add(24 * time.Hour).Unix(),
-		Audience:  "jibeshshrestha",
-	})
+	err := app.models.Revocations.Revoke(r.Context(), claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	family, err := hex.DecodeString(claims.SessionID)
+	if err == nil && len(family) > 0 {
+		err = app.models.Tokens.DeleteFamily(r.Context(), family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-	refreshToken, err := refreshClaims.SignedString([]byte(SecretKey))
+	err = app.WriteJSON(w, r, Envelope{"message": "you have been logged out"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logs the user out of every session
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllSessions(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.WriteJSON(w, r, map[string]any{"access_token": accessToken, "refresh_token": refreshToken}, nil, http.StatusCreated)
+	err = app.WriteJSON(w, r, Envelope{"message": "you have been logged out of every session"}, nil, http.StatusOK)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) refreshJWTtoken(w http.ResponseWriter, r *http.Request) {
+func (app *application) revokeAllSessions(ctx context.Context, userID int64) error {
+	err := app.models.Revocations.RevokeAllForUser(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, scope := range []string{data.ScopeRefresh, data.ScopeAuthentication} {
+		err = app.models.Tokens.DeleteForUser(ctx, scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// always answers the same way so the endpoint can't be used to find out
+// which email addresses have an account
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = validation.Validate(input.Email, validation.Required, is.Email)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"email": err.Error()})
+		return
+	}
+
+	user, err := app.models.Users.GetUserByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	if user != nil {
+		token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Token,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.log.Error(err.Error())
+			}
+		})
+	}
+
+	message := "if an account with that email address exists you will receive an email with password reset instructions"
+	err = app.WriteJSON(w, r, Envelope{"message": message}, nil, http.StatusAccepted)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
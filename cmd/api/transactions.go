@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+func (app *application) listTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	filters := data.TransactionFilters{
+		From:      app.readTime(qs, "from", false, errs),
+		To:        app.readTime(qs, "to", true, errs),
+		Currency:  strings.ToUpper(app.readString(qs, "currency", "")),
+		Direction: app.readString(qs, "direction", ""),
+		Search:    app.readString(qs, "q", ""),
+		Page: data.Page{
+			Sort:         app.readString(qs, "sort", "-created_at"),
+			SortSafelist: []string{"created_at", "amount", "-created_at", "-amount"},
+			Cursor:       app.readString(qs, "cursor", ""),
+			Limit:        app.readInt(qs, "limit", 20, errs),
+		},
+	}
+
+	filters.CounterpartyID = int64(app.readInt(qs, "counterparty_id", 0, errs))
+
+	// amounts are decimals in the currency being filtered on
+	for key, dst := range map[string]**data.Money{"min_amount": &filters.MinAmount, "max_amount": &filters.MaxAmount} {
+		if s := qs.Get(key); s != "" {
+			amount, err := data.ParseMoney(s, filters.Currency)
+			if err != nil {
+				errs[key] = err.Error()
+				continue
+			}
+			*dst = &amount
+		}
+	}
+
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	err := filters.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	transactions, metadata, err := app.models.Transactions.GetAllForUser(r.Context(), user.ID, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.failedValidationResponse(w, r, map[string]string{"cursor": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"transactions": transactions, "metadata": metadata}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
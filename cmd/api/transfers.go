@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bankapi/internal/data"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func (app *application) createTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ToUserID    int64      `json:"to_user_id"`
+		Amount      data.Money `json:"amount"`
+		Description string     `json:"description"`
+	}
+
+	err := app.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// the key is bound to the decoded request, so a retry with the same key
+	// but a different body is refused instead of replaying the wrong response
+	var key *data.IdempotencyKey
+	if header := r.Header.Get("Idempotency-Key"); header != "" {
+		request, err := json.Marshal(input)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		key = data.NewIdempotencyKey(user.ID, header, request)
+		if err := key.Validate(); err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"idempotency_key": err.Error()})
+			return
+		}
+
+		if app.replayIdempotencyKey(w, r, key) {
+			return
+		}
+	}
+
+	transfer := &data.Transfer{
+		FromUserID:  user.ID,
+		ToUserID:    input.ToUserID,
+		Amount:      input.Amount,
+		Description: input.Description,
+	}
+
+	err = transfer.Validate()
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	_, err = app.models.Users.Get(r.Context(), transfer.ToUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"to_user_id": "no user with this id"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	respond := func(t *data.Transfer) (int, []byte, error) {
+		body, err := json.Marshal(Envelope{"transfer": t})
+		return http.StatusCreated, body, err
+	}
+
+	err = app.models.Transfers.Create(r.Context(), transfer, key, respond)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInsufficientFunds):
+			app.failedValidationResponse(w, r, map[string]string{"amount": "insufficient funds"})
+		case errors.Is(err, data.ErrAccountNotActive):
+			app.failedValidationResponse(w, r, map[string]string{"account": "the account is frozen or closed"})
+		case errors.Is(err, data.ErrDuplicateIdempotencyKey), errors.Is(err, data.ErrEditConflict):
+			// a concurrent request with the same key may have won the race
+			if key != nil && app.replayIdempotencyKey(w, r, key) {
+				return
+			}
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.WriteJSON(w, r, Envelope{"transfer": transfer}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replayIdempotencyKey answers with the stored response if the key was used
+// before, it returns false when the request still has to be handled
+func (app *application) replayIdempotencyKey(w http.ResponseWriter, r *http.Request, key *data.IdempotencyKey) bool {
+	stored, err := app.models.Idempotency.Get(r.Context(), key.UserID, key.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false
+		default:
+			app.serverErrorResponse(w, r, err)
+			return true
+		}
+	}
+
+	if !stored.Matches(key) {
+		app.failedValidationResponse(w, r, map[string]string{"idempotency_key": data.ErrIdempotencyKeyMismatch.Error()})
+		return true
+	}
+
+	headers := make(http.Header)
+	headers.Set("Idempotent-Replayed", "true")
+
+	err = app.WriteJSON(w, r, json.RawMessage(stored.Response), headers, stored.Status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+	return true
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti text PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    revoked_before timestamp(0) with time zone NOT NULL
+);
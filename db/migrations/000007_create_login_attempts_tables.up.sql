@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS failed_logins (
+    id bigserial PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    ip text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS failed_logins_user_id_idx ON failed_logins (user_id, created_at);
+CREATE INDEX IF NOT EXISTS failed_logins_ip_idx ON failed_logins (ip, created_at);
+
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    locked_until timestamp(0) with time zone NOT NULL
+);
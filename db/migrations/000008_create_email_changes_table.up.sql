@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    token_hash bytea PRIMARY KEY REFERENCES tokens (hash) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    email citext NOT NULL
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM permissions
+WHERE code IN ('accounts:read', 'accounts:write', 'transfers:write', 'users:read', 'users:write', 'permissions:read', 'permissions:write', 'audit:read');
+
+DROP INDEX IF EXISTS permissions_code_idx;
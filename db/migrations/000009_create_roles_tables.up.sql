@@ -0,0 +1,70 @@
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_idx ON permissions (code);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('accounts:read'),
+    ('accounts:write'),
+    ('transfers:write'),
+    ('users:read'),
+    ('users:write'),
+    ('permissions:read'),
+    ('permissions:write'),
+    ('audit:read')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO roles (name)
+VALUES
+    ('customer'),
+    ('teller'),
+    ('auditor'),
+    ('admin')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM (VALUES
+    ('customer', 'accounts:read'),
+    ('customer', 'accounts:write'),
+    ('customer', 'transfers:write'),
+    ('teller', 'accounts:read'),
+    ('teller', 'users:read'),
+    ('auditor', 'accounts:read'),
+    ('auditor', 'users:read'),
+    ('auditor', 'permissions:read'),
+    ('auditor', 'audit:read'),
+    ('admin', 'accounts:read'),
+    ('admin', 'accounts:write'),
+    ('admin', 'transfers:write'),
+    ('admin', 'users:read'),
+    ('admin', 'users:write'),
+    ('admin', 'permissions:read'),
+    ('admin', 'permissions:write'),
+    ('admin', 'audit:read')
+) AS grants (role, code)
+INNER JOIN roles ON roles.name = grants.role
+INNER JOIN permissions ON permissions.code = grants.code
+ON CONFLICT DO NOTHING;
+
+-- everyone who registered so far is a customer
+INSERT INTO users_roles (user_id, role_id)
+SELECT users.id, roles.id
+FROM users, roles
+WHERE roles.name = 'customer'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    target_user_id bigint REFERENCES users ON DELETE SET NULL,
+    details jsonb NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log (target_user_id, created_at);
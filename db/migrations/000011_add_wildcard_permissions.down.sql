@@ -0,0 +1,21 @@
+DELETE FROM permissions WHERE code IN ('*', '*:read');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM (VALUES
+    ('auditor', 'accounts:read'),
+    ('auditor', 'users:read'),
+    ('auditor', 'permissions:read'),
+    ('auditor', 'audit:read'),
+    ('admin', 'accounts:read'),
+    ('admin', 'accounts:write'),
+    ('admin', 'transfers:write'),
+    ('admin', 'users:read'),
+    ('admin', 'users:write'),
+    ('admin', 'permissions:read'),
+    ('admin', 'permissions:write'),
+    ('admin', 'audit:read')
+) AS grants (role, code)
+INNER JOIN roles ON roles.name = grants.role
+INNER JOIN permissions ON permissions.code = grants.code
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,21 @@
+INSERT INTO permissions (code)
+VALUES
+    ('*'),
+    ('*:read')
+ON CONFLICT (code) DO NOTHING;
+
+-- admins and auditors get wildcards instead of every code enumerated
+DELETE FROM roles_permissions
+USING roles
+WHERE roles_permissions.role_id = roles.id
+AND roles.name IN ('admin', 'auditor');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM (VALUES
+    ('admin', '*'),
+    ('auditor', '*:read')
+) AS grants (role, code)
+INNER JOIN roles ON roles.name = grants.role
+INNER JOIN permissions ON permissions.code = grants.code
+ON CONFLICT DO NOTHING;
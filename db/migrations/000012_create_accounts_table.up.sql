@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id bigserial PRIMARY KEY,
+    account_number text UNIQUE NOT NULL,
+    type text NOT NULL CHECK (type IN ('checking', 'savings')),
+    currency char(3) NOT NULL,
+    status text NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'frozen', 'closed')),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    version integer NOT NULL DEFAULT 1,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    closed_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS accounts_user_id_idx ON accounts (user_id);
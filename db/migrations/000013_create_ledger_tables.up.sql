@@ -0,0 +1,85 @@
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id bigserial PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE RESTRICT,
+    name text NOT NULL,
+    currency char(3) NOT NULL,
+    allow_negative bool NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- user_id is NULL for the bank's own accounts
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accounts_user_idx ON ledger_accounts (user_id, name, currency) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accounts_system_idx ON ledger_accounts (name, currency) WHERE user_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id bigserial PRIMARY KEY,
+    description text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id bigserial PRIMARY KEY,
+    journal_entry_id bigint NOT NULL REFERENCES journal_entries,
+    ledger_account_id bigint NOT NULL REFERENCES ledger_accounts,
+    amount bigint NOT NULL CHECK (amount <> 0),
+    currency char(3) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS postings_journal_entry_id_idx ON postings (journal_entry_id);
+CREATE INDEX IF NOT EXISTS postings_ledger_account_id_idx ON postings (ledger_account_id, id);
+
+CREATE TABLE IF NOT EXISTS ledger_balances (
+    ledger_account_id bigint PRIMARY KEY REFERENCES ledger_accounts,
+    balance bigint NOT NULL DEFAULT 0,
+    last_posting_id bigint NOT NULL DEFAULT 0,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER journal_entries_append_only
+BEFORE UPDATE OR DELETE ON journal_entries
+FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER journal_entries_no_truncate
+BEFORE TRUNCATE ON journal_entries
+FOR EACH STATEMENT EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER postings_append_only
+BEFORE UPDATE OR DELETE ON postings
+FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER postings_no_truncate
+BEFORE TRUNCATE ON postings
+FOR EACH STATEMENT EXECUTE FUNCTION ledger_append_only();
+
+-- checked when the transaction commits, so every posting of an entry is in
+-- place by then no matter the order they were inserted in
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT COUNT(*) FROM postings WHERE journal_entry_id = NEW.journal_entry_id) < 2 THEN
+        RAISE EXCEPTION 'journal entry % needs at least two postings', NEW.journal_entry_id;
+    END IF;
+
+    IF EXISTS (
+        SELECT 1
+        FROM postings
+        WHERE journal_entry_id = NEW.journal_entry_id
+        GROUP BY currency
+        HAVING SUM(amount) <> 0
+    ) THEN
+        RAISE EXCEPTION 'journal entry % does not balance', NEW.journal_entry_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+AFTER INSERT ON postings
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
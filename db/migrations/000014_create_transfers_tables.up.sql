@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS transfers (
+    id bigserial PRIMARY KEY,
+    from_user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    to_user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    amount bigint NOT NULL CHECK (amount > 0),
+    currency char(3) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    journal_entry_id bigint NOT NULL REFERENCES journal_entries,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (from_user_id <> to_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS transfers_from_user_id_idx ON transfers (from_user_id);
+CREATE INDEX IF NOT EXISTS transfers_to_user_id_idx ON transfers (to_user_id);
+
+-- the response is stored in the same transaction as the request's side
+-- effects, so a key is never visible without its response
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    key text NOT NULL,
+    request_hash bytea NOT NULL,
+    status integer NOT NULL,
+    response bytea NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'fx:write';
+
+DROP TABLE IF EXISTS fx_quotes;
+DROP TABLE IF EXISTS fx_rates;
@@ -0,0 +1,30 @@
+-- a rate applies from effective_at until the next row for the same pair
+CREATE TABLE IF NOT EXISTS fx_rates (
+    id bigserial PRIMARY KEY,
+    base char(3) NOT NULL,
+    quote char(3) NOT NULL,
+    rate numeric(24, 12) NOT NULL CHECK (rate > 0),
+    effective_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (base <> quote),
+    UNIQUE (base, quote, effective_at)
+);
+
+CREATE TABLE IF NOT EXISTS fx_quotes (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    from_currency char(3) NOT NULL,
+    from_amount bigint NOT NULL CHECK (from_amount > 0),
+    to_currency char(3) NOT NULL,
+    to_amount bigint NOT NULL CHECK (to_amount > 0),
+    rate numeric(24, 12) NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    redeemed_at timestamp(0) with time zone,
+    journal_entry_id bigint REFERENCES journal_entries
+);
+
+CREATE INDEX IF NOT EXISTS fx_quotes_user_id_idx ON fx_quotes (user_id);
+
+INSERT INTO permissions (code)
+VALUES ('fx:write')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,40 @@
+-- each user's view of the ledger: one row per side of a journal entry that
+-- touches them, amounts are always positive with the direction saying which way
+CREATE TABLE IF NOT EXISTS transactions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    journal_entry_id bigint NOT NULL REFERENCES journal_entries,
+    direction text NOT NULL CHECK (direction IN ('credit', 'debit')),
+    amount bigint NOT NULL CHECK (amount > 0),
+    currency char(3) NOT NULL,
+    counterparty_id bigint REFERENCES users ON DELETE SET NULL,
+    counterparty text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    search tsvector GENERATED ALWAYS AS (to_tsvector('simple', description || ' ' || counterparty)) STORED
+);
+
+-- keyset pagination walks these, id breaks ties between equal values
+CREATE INDEX IF NOT EXISTS transactions_user_created_at_idx ON transactions (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS transactions_user_amount_idx ON transactions (user_id, amount, id);
+CREATE INDEX IF NOT EXISTS transactions_search_idx ON transactions USING GIN (search);
+
+INSERT INTO transactions (user_id, journal_entry_id, direction, amount, currency, counterparty_id, counterparty, description, created_at)
+SELECT transfers.from_user_id, transfers.journal_entry_id, 'debit', transfers.amount, transfers.currency,
+    transfers.to_user_id, users.username, transfers.description, transfers.created_at
+FROM transfers
+INNER JOIN users ON users.id = transfers.to_user_id
+UNION ALL
+SELECT transfers.to_user_id, transfers.journal_entry_id, 'credit', transfers.amount, transfers.currency,
+    transfers.from_user_id, users.username, transfers.description, transfers.created_at
+FROM transfers
+INNER JOIN users ON users.id = transfers.from_user_id
+UNION ALL
+SELECT user_id, journal_entry_id, 'debit', from_amount, from_currency, NULL, '', 'currency conversion', redeemed_at
+FROM fx_quotes
+WHERE journal_entry_id IS NOT NULL
+UNION ALL
+SELECT user_id, journal_entry_id, 'credit', to_amount, to_currency, NULL, '', 'currency conversion', redeemed_at
+FROM fx_quotes
+WHERE journal_entry_id IS NOT NULL
+ORDER BY 9, 2;
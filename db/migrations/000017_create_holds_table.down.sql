@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'holds:write';
+
+DROP TABLE IF EXISTS holds;
+ALTER TABLE ledger_balances DROP COLUMN IF EXISTS held;
@@ -0,0 +1,24 @@
+-- held is the part of the balance reserved by active holds, the available
+-- balance is balance - held
+ALTER TABLE ledger_balances ADD COLUMN IF NOT EXISTS held bigint NOT NULL DEFAULT 0 CHECK (held >= 0);
+
+CREATE TABLE IF NOT EXISTS holds (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    ledger_account_id bigint NOT NULL REFERENCES ledger_accounts,
+    amount bigint NOT NULL CHECK (amount > 0),
+    captured bigint NOT NULL DEFAULT 0 CHECK (captured >= 0 AND captured <= amount),
+    currency char(3) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'captured', 'released', 'expired')),
+    expires_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds (user_id);
+CREATE INDEX IF NOT EXISTS holds_active_expires_at_idx ON holds (expires_at) WHERE status = 'active';
+
+INSERT INTO permissions (code)
+VALUES ('holds:write')
+ON CONFLICT (code) DO NOTHING;
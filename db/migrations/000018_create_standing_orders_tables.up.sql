@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS standing_orders (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    to_user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    amount bigint NOT NULL CHECK (amount > 0),
+    currency char(3) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    schedule text NOT NULL,
+    -- NULL once the schedule has no more runs
+    next_run_at timestamp(0) with time zone,
+    end_at timestamp(0) with time zone,
+    status text NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'finished', 'cancelled')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CHECK (user_id <> to_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS standing_orders_user_id_idx ON standing_orders (user_id);
+CREATE INDEX IF NOT EXISTS standing_orders_due_idx ON standing_orders (next_run_at) WHERE status = 'active';
+
+-- one row per scheduled run, the unique key stops a run from happening twice
+CREATE TABLE IF NOT EXISTS standing_order_runs (
+    id bigserial PRIMARY KEY,
+    standing_order_id bigint NOT NULL REFERENCES standing_orders ON DELETE CASCADE,
+    scheduled_for timestamp(0) with time zone NOT NULL,
+    succeeded bool NOT NULL,
+    error text NOT NULL DEFAULT '',
+    transfer_id bigint REFERENCES transfers,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (standing_order_id, scheduled_for)
+);
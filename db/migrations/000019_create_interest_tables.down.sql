@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'interest:write';
+
+DROP TABLE IF EXISTS interest_postings;
+DROP TABLE IF EXISTS interest_accruals;
+DROP TABLE IF EXISTS balance_interest;
+DROP TABLE IF EXISTS interest_products;
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS interest_products (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL,
+    annual_rate numeric(24, 12) NOT NULL CHECK (annual_rate >= 0),
+    day_count text NOT NULL CHECK (day_count IN ('ACT/365', '30/360')),
+    compounding text NOT NULL CHECK (compounding IN ('daily', 'monthly')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- a balance earns interest under at most one product
+CREATE TABLE IF NOT EXISTS balance_interest (
+    ledger_account_id bigint PRIMARY KEY REFERENCES ledger_accounts,
+    product_id bigint NOT NULL REFERENCES interest_products,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- amounts are in minor units, kept to 18 decimal places so nothing is lost
+-- before the monthly posting rounds them
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    ledger_account_id bigint NOT NULL REFERENCES ledger_accounts,
+    day date NOT NULL,
+    product_id bigint NOT NULL REFERENCES interest_products,
+    base numeric(38, 18) NOT NULL,
+    amount numeric(38, 18) NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (ledger_account_id, day)
+);
+
+-- accrued is the exact interest of the month plus what rounding left over
+-- from earlier months, posted is the whole minor units paid out
+CREATE TABLE IF NOT EXISTS interest_postings (
+    ledger_account_id bigint NOT NULL REFERENCES ledger_accounts,
+    month date NOT NULL,
+    accrued numeric(38, 18) NOT NULL,
+    posted bigint NOT NULL,
+    journal_entry_id bigint REFERENCES journal_entries,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (ledger_account_id, month)
+);
+
+INSERT INTO permissions (code)
+VALUES ('interest:write')
+ON CONFLICT (code) DO NOTHING;
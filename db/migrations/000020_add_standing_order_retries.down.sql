@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS standing_orders_due_idx;
+CREATE INDEX IF NOT EXISTS standing_orders_due_idx ON standing_orders (next_run_at) WHERE status = 'active';
+
+ALTER TABLE standing_orders DROP COLUMN IF EXISTS retry_at;
+ALTER TABLE standing_orders DROP COLUMN IF EXISTS attempts;
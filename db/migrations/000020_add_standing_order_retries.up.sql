@@ -0,0 +1,7 @@
+-- transfers that fail for reasons other than the payer's balance are retried
+-- with a backoff instead of holding up every order due after them
+ALTER TABLE standing_orders ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE standing_orders ADD COLUMN IF NOT EXISTS retry_at timestamp(0) with time zone;
+
+DROP INDEX IF EXISTS standing_orders_due_idx;
+CREATE INDEX IF NOT EXISTS standing_orders_due_idx ON standing_orders ((COALESCE(retry_at, next_run_at))) WHERE status = 'active';
@@ -0,0 +1,3 @@
+-- access tokens carry their issue time in microseconds, revoke-all has to be
+-- as precise or a login in the same second as the revocation is rejected
+ALTER TABLE user_token_revocations ALTER COLUMN revoked_before TYPE timestamp with time zone;
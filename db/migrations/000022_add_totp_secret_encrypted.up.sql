@@ -0,0 +1,3 @@
+-- secrets stored before -totp-key was set stay readable and are encrypted the
+-- next time the user is updated
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret_encrypted bool NOT NULL DEFAULT false;
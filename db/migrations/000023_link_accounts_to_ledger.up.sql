@@ -0,0 +1,24 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS ledger_account_id bigint REFERENCES ledger_accounts;
+
+-- existing accounts are linked to the balance the ledger keeps for their
+-- owner in the currency, opening it where there isn't one yet
+INSERT INTO ledger_accounts (user_id, name, currency, allow_negative)
+SELECT DISTINCT user_id, 'balance', currency, false
+FROM accounts
+ON CONFLICT (user_id, name, currency) WHERE user_id IS NOT NULL DO NOTHING;
+
+INSERT INTO ledger_balances (ledger_account_id)
+SELECT id FROM ledger_accounts
+ON CONFLICT (ledger_account_id) DO NOTHING;
+
+UPDATE accounts
+SET ledger_account_id = ledger_accounts.id
+FROM ledger_accounts
+WHERE ledger_accounts.user_id = accounts.user_id
+AND ledger_accounts.name = 'balance'
+AND ledger_accounts.currency = accounts.currency
+AND accounts.ledger_account_id IS NULL;
+
+ALTER TABLE accounts ALTER COLUMN ledger_account_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS accounts_ledger_account_id_idx ON accounts (ledger_account_id);
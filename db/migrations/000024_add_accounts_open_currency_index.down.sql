@@ -0,0 +1 @@
+DROP INDEX IF EXISTS accounts_open_currency_idx;
@@ -0,0 +1,4 @@
+-- the ledger keeps one balance per user and currency, so only one account in
+-- a currency can be open at a time. Users with several open accounts in a
+-- currency have to have them merged before this can run
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_open_currency_idx ON accounts (user_id, currency) WHERE status <> 'closed';
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so the api binary can apply
+// them itself, see internal/migrate
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
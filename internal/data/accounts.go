@@ -0,0 +1,316 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	AccountTypeChecking = "checking"
+	AccountTypeSavings  = "savings"
+
+	AccountStatusActive = "active"
+	AccountStatusFrozen = "frozen"
+	AccountStatusClosed = "closed"
+)
+
+var (
+	// ErrAccountNotEmpty is returned when closing an account whose ledger
+	// account still has a balance or money held
+	ErrAccountNotEmpty = errors.New("account still has a balance or money held")
+	// ErrDuplicateAccount is returned when the user already has an open
+	// account in the currency
+	ErrDuplicateAccount = errors.New("an open account in this currency already exists")
+)
+
+// Account is a customer facing account. Its money lives in the ledger, in
+// the owner's balance account for the currency. That ledger account is one
+// per user and currency, so a user can only have one account open in each
+// currency, which the accounts_open_currency_idx index enforces.
+type Account struct {
+	ID              int64      `json:"id"`
+	Number          string     `json:"account_number"`
+	Type            string     `json:"type"`
+	Currency        string     `json:"currency"`
+	Status          string     `json:"status"`
+	UserID          int64      `json:"-"`
+	LedgerAccountID int64      `json:"-"`
+	Version         int        `json:"-"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ClosedAt        *time.Time `json:"closed_at,omitempty"`
+}
+
+func (a Account) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.Type, validation.Required, validation.In(AccountTypeChecking, AccountTypeSavings)),
+		validation.Field(&a.Currency, validation.Required, is.CurrencyCode),
+	)
+}
+
+// generateAccountNumber returns 11 random digits followed by a Luhn check
+// digit, so typos in an account number are caught before any lookup
+func generateAccountNumber() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1e11))
+	if err != nil {
+		return "", err
+	}
+
+	digits := []byte(n.Text(10))
+	for len(digits) < 11 {
+		digits = append([]byte{'0'}, digits...)
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		// doubling starts with the rightmost digit since the check digit goes after it
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return string(digits) + string(rune('0'+(10-sum%10)%10)), nil
+}
+
+type AccountModel struct {
+	DB DBTX
+}
+
+// Insert opens the account along with the ledger account behind it, if the
+// user doesn't have one in the currency yet
+func (m AccountModel) Insert(ctx context.Context, account *Account) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ledgerAccount, err := userLedgerAccount(ctx, tx, account.UserID, account.Currency)
+	if err != nil {
+		return err
+	}
+
+	account.LedgerAccountID = ledgerAccount.ID
+
+	query := `
+		INSERT INTO accounts (account_number, type, currency, status, user_id, ledger_account_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (account_number) DO NOTHING
+		RETURNING id, created_at, version;
+	`
+
+	// account numbers are random, so on the rare collision just draw another one
+	for attempt := 0; ; attempt++ {
+		number, err := generateAccountNumber()
+		if err != nil {
+			return err
+		}
+
+		args := []any{number, account.Type, account.Currency, account.Status, account.UserID, account.LedgerAccountID}
+
+		err = tx.QueryRow(ctx, query, args...).Scan(&account.ID, &account.CreatedAt, &account.Version)
+		if err != nil {
+			var e *pgconn.PgError
+			switch {
+			case errors.Is(err, pgx.ErrNoRows) && attempt < 3:
+				continue
+			case errors.As(err, &e) && e.ConstraintName == "accounts_open_currency_idx":
+				return ErrDuplicateAccount
+			}
+			return err
+		}
+
+		account.Number = number
+		return tx.Commit(ctx)
+	}
+}
+
+func (m AccountModel) Get(ctx context.Context, id int64) (*Account, error) {
+	query := `
+		SELECT id, account_number, type, currency, status, user_id, ledger_account_id, version, created_at, closed_at
+		FROM accounts
+		WHERE id = $1
+	`
+
+	var account Account
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&account.ID,
+		&account.Number,
+		&account.Type,
+		&account.Currency,
+		&account.Status,
+		&account.UserID,
+		&account.LedgerAccountID,
+		&account.Version,
+		&account.CreatedAt,
+		&account.ClosedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &account, nil
+}
+
+func (m AccountModel) GetAllForUser(ctx context.Context, userID int64) ([]*Account, error) {
+	query := `
+		SELECT id, account_number, type, currency, status, user_id, ledger_account_id, version, created_at, closed_at
+		FROM accounts
+		WHERE user_id = $1
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+
+	for rows.Next() {
+		var account Account
+
+		err := rows.Scan(
+			&account.ID,
+			&account.Number,
+			&account.Type,
+			&account.Currency,
+			&account.Status,
+			&account.UserID,
+			&account.LedgerAccountID,
+			&account.Version,
+			&account.CreatedAt,
+			&account.ClosedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, &account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func (m AccountModel) Update(ctx context.Context, account *Account) error {
+	query := `
+		UPDATE accounts
+		SET type = $1, status = $2, closed_at = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version;
+	`
+
+	args := []any{
+		account.Type,
+		account.Status,
+		account.ClosedAt,
+		account.ID,
+		account.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&account.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the account, or returns ErrAccountNotEmpty while its ledger
+// account has a balance or money held. The balance stays locked until the
+// account is closed, and postJournalEntry and holds check the account is
+// still active once they hold the same lock, so nothing gets in between or
+// after.
+func (m AccountModel) Close(ctx context.Context, account *Account) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT balance, held
+		FROM ledger_balances
+		WHERE ledger_account_id = $1
+		FOR UPDATE
+	`
+
+	var balance, held int64
+
+	err = tx.QueryRow(ctx, query, account.LedgerAccountID).Scan(&balance, &held)
+	if err != nil {
+		return err
+	}
+
+	if balance != 0 || held != 0 {
+		return ErrAccountNotEmpty
+	}
+
+	closedAt := time.Now()
+
+	query = `
+		UPDATE accounts
+		SET status = $1, closed_at = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version;
+	`
+
+	err = tx.QueryRow(ctx, query, AccountStatusClosed, closedAt, account.ID, account.Version).Scan(&account.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	account.Status = AccountStatusClosed
+	account.ClosedAt = &closedAt
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type AuditEntry struct {
+	ID           int64          `json:"id"`
+	ActorID      int64          `json:"actor_id"`
+	Action       string         `json:"action"`
+	TargetUserID *int64         `json:"target_user_id,omitempty"`
+	Details      map[string]any `json:"details"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// AuditModel is append only, entries are never updated or deleted
+type AuditModel struct {
+	DB DBTX
+}
+
+func (m AuditModel) Insert(ctx context.Context, entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, action, target_user_id, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+
+	if entry.Details == nil {
+		entry.Details = map[string]any{}
+	}
+
+	args := []any{entry.ActorID, entry.Action, entry.TargetUserID, entry.Details}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll returns the latest entries first, targetUserID 0 returns entries for every user
+func (m AuditModel) GetAll(ctx context.Context, targetUserID int64, limit int) ([]*AuditEntry, error) {
+	query := `
+		SELECT id, COALESCE(actor_id, 0), action, target_user_id, details, created_at
+		FROM audit_log
+		WHERE (target_user_id = $1 OR $1 = 0)
+		ORDER BY id DESC
+		LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, targetUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.TargetUserID,
+			&entry.Details,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// EmailChangeModel keeps the new address of a pending email change next to
+// the token that was sent to it. The address only replaces users.email once
+// the token has been confirmed.
+type EmailChangeModel struct {
+	DB DBTX
+}
+
+// New issues an email-change token for the address, any earlier pending change
+// of the same user is discarded
+func (m EmailChangeModel) New(ctx context.Context, userID int64, email string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2;`, ScopeEmailChange, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope) 
+		VALUES ($1, $2, $3, $4);
+	`
+
+	_, err = tx.Exec(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO email_changes (token_hash, user_id, email)
+		VALUES ($1, $2, $3);
+	`
+
+	_, err = tx.Exec(ctx, query, token.Hash, userID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetForToken returns the pending address an unexpired email-change token confirms
+func (m EmailChangeModel) GetForToken(ctx context.Context, tokenPlaintext string) (string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		SELECT email_changes.email
+		FROM email_changes
+		INNER JOIN tokens
+		ON email_changes.token_hash = tokens.hash
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3;
+	`
+
+	var email string
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, tokenHash[:], ScopeEmailChange, time.Now()).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
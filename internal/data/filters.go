@@ -0,0 +1,91 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page describes how a list is sorted and where to continue from. Sort is a
+// column name from SortSafelist, prefixed with - for descending order
+type Page struct {
+	Sort         string
+	SortSafelist []string
+	Cursor       string
+	Limit        int
+}
+
+func (p Page) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Sort, validation.Required, validation.By(func(any) error {
+			if !slices.Contains(p.SortSafelist, p.Sort) {
+				return errors.New("invalid sort value")
+			}
+			return nil
+		})),
+		validation.Field(&p.Limit, validation.Required, validation.Min(1), validation.Max(100)),
+	)
+}
+
+// sortColumn only ever returns a name from the safelist, so it's safe to put
+// into a query
+func (p Page) sortColumn() string {
+	for _, safe := range p.SortSafelist {
+		if p.Sort == safe {
+			return strings.TrimPrefix(p.Sort, "-")
+		}
+	}
+
+	panic("unsafe sort parameter: " + p.Sort)
+}
+
+func (p Page) sortDirection() string {
+	if strings.HasPrefix(p.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// cursor points just past the last row of a page, it's opaque to clients and
+// only valid with the sort it was made for
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s, sort string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.Sort != sort || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+type Metadata struct {
+	Sort       string `json:"sort"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
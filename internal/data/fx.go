@@ -0,0 +1,387 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ledger accounts of the bank that take the other side of every conversion
+const LedgerAccountFX = "fx"
+
+// rates are stored with this many decimal places
+const rateScale = 12
+
+var (
+	ErrInvalidRate    = errors.New("rate must be a positive decimal number with at most 12 decimal places")
+	ErrAmountTooSmall = errors.New("amount is too small to convert")
+	ErrSameCurrency   = errors.New("can't convert a currency into itself")
+)
+
+// Rate is an exact exchange rate, kept as a fraction so conversions never go
+// through a float
+type Rate struct {
+	r *big.Rat
+}
+
+func ParseRate(s string) (Rate, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > rateScale || strings.Trim(whole+frac, "0123456789") != "" {
+		return Rate{}, ErrInvalidRate
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() <= 0 {
+		return Rate{}, ErrInvalidRate
+	}
+
+	return Rate{r: r}, nil
+}
+
+// Rat returns a copy of the rate
+func (r Rate) Rat() *big.Rat {
+	if r.r == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(r.r)
+}
+
+// Inverse rounds to the stored precision like any other rate, so a quote made
+// with it converts at exactly the rate it shows
+func (r Rate) Inverse() Rate {
+	inv, _ := numericRat(ratNumeric(new(big.Rat).Inv(r.Rat()), rateScale))
+	return Rate{r: inv}
+}
+
+// String formats the rate as a decimal, rounded to the stored precision
+func (r Rate) String() string {
+	s := r.Rat().FloatString(rateScale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r Rate) NumericValue() (pgtype.Numeric, error) {
+	return ratNumeric(r.Rat(), rateScale), nil
+}
+
+func (r *Rate) ScanNumeric(v pgtype.Numeric) error {
+	rat, ok := numericRat(v)
+	if !ok {
+		return ErrInvalidRate
+	}
+
+	r.r = rat
+	return nil
+}
+
+// Convert exchanges the money at rate into currency, rounding half to even to
+// the minor unit of the new currency
+func (m Money) Convert(rate Rate, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+
+	// rates are between major units, so shift by the difference in exponents
+	r := rate.Rat()
+	shift := CurrencyExponent(currency) - CurrencyExponent(m.currency)
+	pow := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(max(shift, -shift))), nil))
+	if shift > 0 {
+		r.Mul(r, pow)
+	} else {
+		r.Quo(r, pow)
+	}
+
+	converted, err := m.Mul(r)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{amount: converted.amount, currency: currency}, nil
+}
+
+// FXRate says how much of Quote one unit of Base buys from EffectiveAt on
+type FXRate struct {
+	ID          int64     `json:"id"`
+	Base        string    `json:"base"`
+	Quote       string    `json:"quote"`
+	Rate        Rate      `json:"rate"`
+	EffectiveAt time.Time `json:"effective_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (f FXRate) Validate() error {
+	return validation.ValidateStruct(&f,
+		validation.Field(&f.Base, validation.Required, is.CurrencyCode),
+		validation.Field(&f.Quote, validation.Required, is.CurrencyCode, validation.NotIn(f.Base).Error("must differ from base")),
+		validation.Field(&f.EffectiveAt, validation.Required),
+	)
+}
+
+type FXRateModel struct {
+	DB DBTX
+}
+
+// InsertMany stores the rates in one transaction, uploading a rate again for
+// the same pair and time replaces it
+func (m FXRateModel) InsertMany(ctx context.Context, rates []*FXRate) error {
+	query := `
+		INSERT INTO fx_rates (base, quote, rate, effective_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (base, quote, effective_at) DO UPDATE SET rate = EXCLUDED.rate
+		RETURNING id, created_at;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, rate := range rates {
+		args := []any{rate.Base, rate.Quote, rate.Rate, rate.EffectiveAt}
+
+		err = tx.QueryRow(ctx, query, args...).Scan(&rate.ID, &rate.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Current returns the rate in effect at the given time. A pair that is only
+// stored the other way round is answered with the inverse rate
+func (m FXRateModel) Current(ctx context.Context, base, quote string, at time.Time) (*FXRate, error) {
+	query := `
+		SELECT id, base, quote, rate, effective_at, created_at
+		FROM fx_rates
+		WHERE ((base = $1 AND quote = $2) OR (base = $2 AND quote = $1))
+		AND effective_at <= $3
+		ORDER BY effective_at DESC, base = $1 DESC
+		LIMIT 1
+	`
+
+	var rate FXRate
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, base, quote, at).Scan(
+		&rate.ID,
+		&rate.Base,
+		&rate.Quote,
+		&rate.Rate,
+		&rate.EffectiveAt,
+		&rate.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if rate.Base != base {
+		rate.Base, rate.Quote = rate.Quote, rate.Base
+		rate.Rate = rate.Rate.Inverse()
+	}
+
+	return &rate, nil
+}
+
+// FXQuote locks in a rate for a conversion. Like tokens, only the hash of the
+// quote token is stored
+type FXQuote struct {
+	Token      string     `json:"token"`
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"-"`
+	From       Money      `json:"from"`
+	To         Money      `json:"to"`
+	Rate       Rate       `json:"rate"`
+	Expiry     time.Time  `json:"expiry"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+}
+
+type FXQuoteModel struct {
+	DB DBTX
+}
+
+func (m FXQuoteModel) New(ctx context.Context, userID int64, from Money, rate *FXRate, ttl time.Duration) (*FXQuote, error) {
+	if from.Currency() != rate.Base {
+		return nil, ErrCurrencyMismatch
+	}
+
+	to, err := from.Convert(rate.Rate, rate.Quote)
+	if err != nil {
+		return nil, err
+	}
+	if !to.IsPositive() {
+		return nil, ErrAmountTooSmall
+	}
+
+	token, err := generateToken(userID, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &FXQuote{
+		Token:  token.Token,
+		Hash:   token.Hash,
+		UserID: userID,
+		From:   from,
+		To:     to,
+		Rate:   rate.Rate,
+		Expiry: token.Expiry,
+	}
+
+	query := `
+		INSERT INTO fx_quotes (hash, user_id, from_currency, from_amount, to_currency, to_amount, rate, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+	`
+
+	args := []any{
+		quote.Hash,
+		quote.UserID,
+		quote.From.Currency(),
+		quote.From.Amount(),
+		quote.To.Currency(),
+		quote.To.Amount(),
+		quote.Rate,
+		quote.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// Redeem converts the user's money at the quoted rate. Marking the quote
+// redeemed and posting the conversion happen in one transaction, so a quote
+// can only ever be used once and a failed conversion leaves it unused. A
+// transaction that loses a race with another posting is run again
+func (m FXQuoteModel) Redeem(ctx context.Context, tokenPlaintext string, userID int64) (*FXQuote, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var quote *FXQuote
+
+	err := retryTx(ctx, m.DB, pgx.Serializable, func(tx pgx.Tx) error {
+		var err error
+		quote, err = redeemFXQuote(ctx, tx, tokenHash[:], userID)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	quote.Token = tokenPlaintext
+	return quote, nil
+}
+
+func redeemFXQuote(ctx context.Context, tx pgx.Tx, hash []byte, userID int64) (*FXQuote, error) {
+	query := `
+		UPDATE fx_quotes
+		SET redeemed_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND redeemed_at IS NULL AND expiry > NOW()
+		RETURNING from_currency, from_amount, to_currency, to_amount, rate, expiry, redeemed_at
+	`
+
+	quote := FXQuote{Hash: hash, UserID: userID}
+	var fromCurrency, toCurrency string
+	var fromAmount, toAmount int64
+
+	err := tx.QueryRow(ctx, query, hash, userID).Scan(
+		&fromCurrency,
+		&fromAmount,
+		&toCurrency,
+		&toAmount,
+		&quote.Rate,
+		&quote.Expiry,
+		&quote.RedeemedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	quote.From = NewMoney(fromAmount, fromCurrency)
+	quote.To = NewMoney(toAmount, toCurrency)
+
+	userFrom, err := userLedgerAccount(ctx, tx, userID, fromCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	bankFrom, err := systemLedgerAccount(ctx, tx, LedgerAccountFX, fromCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	bankTo, err := systemLedgerAccount(ctx, tx, LedgerAccountFX, toCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	userTo, err := userLedgerAccount(ctx, tx, userID, toCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &JournalEntry{
+		Description: "currency conversion " + quote.From.String() + " to " + quote.To.String(),
+		Postings: []Posting{
+			{LedgerAccountID: userFrom.ID, Amount: quote.From.Neg()},
+			{LedgerAccountID: bankFrom.ID, Amount: quote.From},
+			{LedgerAccountID: bankTo.ID, Amount: quote.To.Neg()},
+			{LedgerAccountID: userTo.ID, Amount: quote.To},
+		},
+	}
+
+	err = postJournalEntry(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE fx_quotes SET journal_entry_id = $1 WHERE hash = $2;`, entry.ID, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertTransactions(ctx, tx,
+		&Transaction{UserID: userID, JournalEntryID: entry.ID, Direction: DirectionDebit, Amount: quote.From, Description: "currency conversion"},
+		&Transaction{UserID: userID, JournalEntryID: entry.ID, Direction: DirectionCredit, Amount: quote.To, Description: "currency conversion"},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quote, nil
+}
@@ -0,0 +1,53 @@
+package data
+
+import "testing"
+
+func TestRateInverse(t *testing.T) {
+	tests := []struct {
+		rate string
+		want string
+	}{
+		{"2", "0.5"},
+		{"0.9", "1.111111111111"},
+		{"3", "0.333333333333"},
+		{"1.5", "0.666666666667"},
+		{"7", "0.142857142857"},
+		{"0.0067", "149.253731343284"},
+	}
+
+	// big enough that rounding in the 12th place of the rate shows in the
+	// converted amount
+	from := NewMoney(10_000_000_000_000, "EUR")
+
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inv := rate.Inverse()
+		if inv.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.rate, inv.String(), tt.want)
+		}
+
+		// the quoted amount has to be what the quoted rate gives
+		shown, err := ParseRate(inv.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := from.Convert(inv, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := from.Convert(shown, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("%s: converted to %v, the shown rate gives %v", tt.rate, got, want)
+		}
+	}
+}
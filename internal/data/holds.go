@@ -0,0 +1,378 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	HoldStatusActive   = "active"
+	HoldStatusCaptured = "captured"
+	HoldStatusReleased = "released"
+	HoldStatusExpired  = "expired"
+
+	// captured money goes to this bank account until it's settled with the
+	// merchant
+	LedgerAccountSettlement = "settlement"
+)
+
+var (
+	ErrHoldNotActive  = errors.New("hold is no longer active")
+	ErrCaptureTooMuch = errors.New("capture amount exceeds what is left of the hold")
+)
+
+// Hold reserves part of a user's balance, for example for a card payment that
+// hasn't settled yet. The held money stays in the ledger balance but can't be
+// spent until the hold is captured, released or expires
+type Hold struct {
+	ID              int64     `json:"id"`
+	UserID          int64     `json:"user_id"`
+	LedgerAccountID int64     `json:"-"`
+	Amount          Money     `json:"amount"`
+	Captured        Money     `json:"captured"`
+	Description     string    `json:"description"`
+	Status          string    `json:"status"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	Version         int       `json:"-"`
+}
+
+func (h Hold) Validate() error {
+	return validation.ValidateStruct(&h,
+		validation.Field(&h.UserID, validation.Required),
+		validation.Field(&h.Amount, PositiveMoney),
+		validation.Field(&h.Description, validation.Length(0, 140)),
+		validation.Field(&h.ExpiresAt, validation.Required, validation.Min(time.Now()).Error("must be in the future")),
+	)
+}
+
+// Remaining is the part of the hold that is still reserved
+func (h Hold) Remaining() Money {
+	remaining, _ := h.Amount.Sub(h.Captured)
+	return remaining
+}
+
+type HoldModel struct {
+	DB DBTX
+}
+
+func (m HoldModel) Insert(ctx context.Context, hold *Hold) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	account, err := userLedgerAccount(ctx, tx, hold.UserID, hold.Amount.Currency())
+	if err != nil {
+		return err
+	}
+
+	// the update only matches while enough of the balance is available, and
+	// the row lock it takes keeps postings from spending the money meanwhile
+	query := `
+		UPDATE ledger_balances
+		SET held = held + $2, updated_at = NOW()
+		WHERE ledger_account_id = $1 AND balance - held >= $2
+	`
+
+	result, err := tx.Exec(ctx, query, account.ID, hold.Amount.Amount())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInsufficientFunds
+	}
+
+	err = checkAccountsActive(ctx, tx, []int64{account.ID})
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO holds (user_id, ledger_account_id, amount, currency, description, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, created_at, version;
+	`
+
+	args := []any{hold.UserID, account.ID, hold.Amount.Amount(), hold.Amount.Currency(), hold.Description, hold.ExpiresAt}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&hold.ID, &hold.Status, &hold.CreatedAt, &hold.Version)
+	if err != nil {
+		return err
+	}
+
+	hold.LedgerAccountID = account.ID
+	hold.Captured = NewMoney(0, hold.Amount.Currency())
+
+	return tx.Commit(ctx)
+}
+
+func (m HoldModel) Get(ctx context.Context, id int64) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return getHold(ctx, m.DB, id, false)
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func getHold(ctx context.Context, db queryRower, id int64, forUpdate bool) (*Hold, error) {
+	query := `
+		SELECT id, user_id, ledger_account_id, amount, captured, currency, description, status, expires_at, created_at, version
+		FROM holds
+		WHERE id = $1
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var hold Hold
+	var amount, captured int64
+	var currency string
+
+	err := db.QueryRow(ctx, query, id).Scan(
+		&hold.ID,
+		&hold.UserID,
+		&hold.LedgerAccountID,
+		&amount,
+		&captured,
+		&currency,
+		&hold.Description,
+		&hold.Status,
+		&hold.ExpiresAt,
+		&hold.CreatedAt,
+		&hold.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	hold.Amount = NewMoney(amount, currency)
+	hold.Captured = NewMoney(captured, currency)
+
+	return &hold, nil
+}
+
+// Capture takes amount of the held money out of the user's balance. A hold
+// can be captured in several parts, it's done once all of it is captured
+func (m HoldModel) Capture(ctx context.Context, id int64, amount Money) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	hold, err := getHold(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.Status != HoldStatusActive || !hold.ExpiresAt.After(time.Now()) {
+		return nil, ErrHoldNotActive
+	}
+
+	cmp, err := amount.Cmp(hold.Remaining())
+	if err != nil {
+		return nil, err
+	}
+	if cmp > 0 {
+		return nil, ErrCaptureTooMuch
+	}
+
+	settlement, err := systemLedgerAccount(ctx, tx, LedgerAccountSettlement, amount.Currency())
+	if err != nil {
+		return nil, err
+	}
+
+	// take both balance rows in id order, the same order postJournalEntry
+	// uses, before touching held
+	err = lockLedgerBalances(ctx, tx, hold.LedgerAccountID, settlement.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE ledger_balances SET held = held - $2 WHERE ledger_account_id = $1;`, hold.LedgerAccountID, amount.Amount())
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &JournalEntry{
+		Description: fmt.Sprintf("capture of hold %d", hold.ID),
+		Postings: []Posting{
+			{LedgerAccountID: hold.LedgerAccountID, Amount: amount.Neg()},
+			{LedgerAccountID: settlement.ID, Amount: amount},
+		},
+	}
+
+	err = postJournalEntry(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertTransactions(ctx, tx, &Transaction{
+		UserID:         hold.UserID,
+		JournalEntryID: entry.ID,
+		Direction:      DirectionDebit,
+		Amount:         amount,
+		Description:    hold.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Captured, err = hold.Captured.Add(amount)
+	if err != nil {
+		return nil, err
+	}
+	if hold.Remaining().IsZero() {
+		hold.Status = HoldStatusCaptured
+	}
+
+	err = updateHold(ctx, tx, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, tx.Commit(ctx)
+}
+
+// Release gives the rest of the hold back to the user's available balance
+func (m HoldModel) Release(ctx context.Context, id int64) (*Hold, error) {
+	return m.finish(ctx, id, HoldStatusReleased)
+}
+
+func (m HoldModel) finish(ctx context.Context, id int64, status string) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	hold, err := getHold(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.Status != HoldStatusActive {
+		return nil, ErrHoldNotActive
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE ledger_balances SET held = held - $2 WHERE ledger_account_id = $1;`, hold.LedgerAccountID, hold.Remaining().Amount())
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Status = status
+
+	err = updateHold(ctx, tx, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, tx.Commit(ctx)
+}
+
+func updateHold(ctx context.Context, tx pgx.Tx, hold *Hold) error {
+	query := `
+		UPDATE holds
+		SET captured = $1, status = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version;
+	`
+
+	args := []any{hold.Captured.Amount(), hold.Status, hold.ID, hold.Version}
+
+	err := tx.QueryRow(ctx, query, args...).Scan(&hold.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExpireDue releases every active hold past its expiry and returns how many
+// there were. Each hold is expired in its own transaction, so one that fails
+// doesn't keep the others reserved
+func (m HoldModel) ExpireDue(ctx context.Context) (int, error) {
+	query := `
+		SELECT id
+		FROM holds
+		WHERE status = 'active' AND expires_at <= NOW()
+		ORDER BY expires_at
+		LIMIT 500
+	`
+
+	queryCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(queryCtx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, id := range ids {
+		_, err := m.finish(ctx, id, HoldStatusExpired)
+		switch {
+		case err == nil:
+			expired++
+		case errors.Is(err, ErrHoldNotActive):
+			// captured or released since it was selected
+		default:
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// lockLedgerBalances locks the balance rows in id order
+func lockLedgerBalances(ctx context.Context, tx pgx.Tx, ids ...int64) error {
+	query := `
+		SELECT ledger_account_id
+		FROM ledger_balances
+		WHERE ledger_account_id = ANY($1)
+		ORDER BY ledger_account_id
+		FOR UPDATE
+	`
+
+	rows, err := tx.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+
+	return rows.Err()
+}
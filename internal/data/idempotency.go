@@ -0,0 +1,118 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrDuplicateIdempotencyKey = errors.New("idempotency key already used")
+	ErrIdempotencyKeyMismatch  = errors.New("idempotency key was used with a different request")
+)
+
+// IdempotencyKey remembers the response to a request made with an
+// Idempotency-Key header, so a retry can be answered with it instead of
+// doing the work again
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash []byte
+	Status      int
+	Response    []byte
+	CreatedAt   time.Time
+}
+
+func NewIdempotencyKey(userID int64, key string, request []byte) *IdempotencyKey {
+	hash := sha256.Sum256(request)
+
+	return &IdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		RequestHash: hash[:],
+	}
+}
+
+func (k IdempotencyKey) Validate() error {
+	return validation.Validate(k.Key, validation.Required, validation.Length(1, 255))
+}
+
+// Matches reports whether the stored key was made by the same request
+func (k IdempotencyKey) Matches(other *IdempotencyKey) bool {
+	return bytes.Equal(k.RequestHash, other.RequestHash)
+}
+
+type IdempotencyModel struct {
+	DB DBTX
+}
+
+func (m IdempotencyModel) Get(ctx context.Context, userID int64, key string) (*IdempotencyKey, error) {
+	query := `
+		SELECT user_id, key, request_hash, status, response, created_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+
+	var k IdempotencyKey
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID, key).Scan(
+		&k.UserID,
+		&k.Key,
+		&k.RequestHash,
+		&k.Status,
+		&k.Response,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &k, nil
+}
+
+// insertIdempotencyKey stores the key with its response inside the
+// transaction that did the work. Two requests racing with the same key can
+// only have one of them commit, the other gets ErrDuplicateIdempotencyKey or
+// ErrEditConflict and can replay the winner's response
+func insertIdempotencyKey(ctx context.Context, tx pgx.Tx, k *IdempotencyKey) error {
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, status, response)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at;
+	`
+
+	args := []any{k.UserID, k.Key, k.RequestHash, k.Status, k.Response}
+
+	err := tx.QueryRow(ctx, query, args...).Scan(&k.CreatedAt)
+	if err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateIdempotencyKey
+		}
+		return err
+	}
+
+	return nil
+}
+
+// serializable transactions that lose a race fail on commit or on any
+// statement, either way the client should just try again
+func isSerializationFailure(err error) bool {
+	var e *pgconn.PgError
+	return errors.As(err, &e) && (e.Code == pgerrcode.SerializationFailure || e.Code == pgerrcode.DeadlockDetected)
+}
@@ -0,0 +1,473 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	DayCountActual365 = "ACT/365"
+	DayCount30360     = "30/360"
+
+	// daily compounding earns interest on interest accrued earlier in the
+	// month, monthly compounding only once it has been posted
+	CompoundingDaily   = "daily"
+	CompoundingMonthly = "monthly"
+
+	// the bank account interest is paid from
+	LedgerAccountInterest = "interest"
+
+	// decimal places accruals are kept to, in minor units
+	accrualScale = 18
+)
+
+var ErrDuplicateInterestProduct = errors.New("an interest product with this name already exists")
+
+type InterestProduct struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	AnnualRate  Rate      `json:"annual_rate"`
+	DayCount    string    `json:"day_count"`
+	Compounding string    `json:"compounding"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (p InterestProduct) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&p.DayCount, validation.Required, validation.In(DayCountActual365, DayCount30360)),
+		validation.Field(&p.Compounding, validation.Required, validation.In(CompoundingDaily, CompoundingMonthly)),
+	)
+}
+
+// dayFraction is the part of a year one day counts for under the convention
+func dayFraction(convention string, day time.Time) *big.Rat {
+	switch convention {
+	case DayCount30360:
+		return big.NewRat(int64(days30360(day, day.AddDate(0, 0, 1))), 360)
+	default:
+		return big.NewRat(1, 365)
+	}
+}
+
+// days30360 counts the days between two dates as if every month had 30 days
+// (the 30/360 bond basis). Across a whole month it always adds up to 30, even
+// though single days can count 0 (the 31st) or 3 (the end of February)
+func days30360(from, to time.Time) int {
+	y1, m1, d1 := from.Date()
+	y2, m2, d2 := to.Date()
+
+	d1 = min(d1, 30)
+	if d1 == 30 {
+		d2 = min(d2, 30)
+	}
+
+	return 360*(y2-y1) + 30*(int(m2)-int(m1)) + (d2 - d1)
+}
+
+type InterestModel struct {
+	DB DBTX
+}
+
+func (m InterestModel) InsertProduct(ctx context.Context, product *InterestProduct) error {
+	query := `
+		INSERT INTO interest_products (name, annual_rate, day_count, compounding)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+
+	args := []any{product.Name, product.AnnualRate, product.DayCount, product.Compounding}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&product.ID, &product.CreatedAt)
+	if err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateInterestProduct
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m InterestModel) GetProducts(ctx context.Context) ([]*InterestProduct, error) {
+	query := `
+		SELECT id, name, annual_rate, day_count, compounding, created_at
+		FROM interest_products
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []*InterestProduct{}
+
+	for rows.Next() {
+		var product InterestProduct
+
+		err := rows.Scan(
+			&product.ID,
+			&product.Name,
+			&product.AnnualRate,
+			&product.DayCount,
+			&product.Compounding,
+			&product.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, &product)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// Attach makes the user's balance in the currency earn interest under the
+// product, replacing the product it earned under before
+func (m InterestModel) Attach(ctx context.Context, userID int64, currency string, productID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	account, err := userLedgerAccount(ctx, tx, userID, currency)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO balance_interest (ledger_account_id, product_id)
+		VALUES ($1, $2)
+		ON CONFLICT (ledger_account_id) DO UPDATE SET product_id = EXCLUDED.product_id
+	`
+
+	_, err = tx.Exec(ctx, query, account.ID, productID)
+	if err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.ForeignKeyViolation {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+type accrualTarget struct {
+	accountID   int64
+	productID   int64
+	rate        Rate
+	dayCount    string
+	compounding string
+}
+
+// Accrue records a day's interest for every balance that earns interest and
+// returns how many accruals it recorded. A balance that already has an
+// accrual for the day is left alone, so a day can be run again safely.
+// Interest is worked out on the balance at the end of the day.
+func (m InterestModel) Accrue(ctx context.Context, day time.Time) (int, error) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	end := day.AddDate(0, 0, 1)
+
+	query := `
+		SELECT balance_interest.ledger_account_id, interest_products.id, interest_products.annual_rate,
+			interest_products.day_count, interest_products.compounding
+		FROM balance_interest
+		INNER JOIN interest_products ON interest_products.id = balance_interest.product_id
+		WHERE balance_interest.created_at < $2
+		AND NOT EXISTS (
+			SELECT 1 FROM interest_accruals
+			WHERE interest_accruals.ledger_account_id = balance_interest.ledger_account_id
+			AND interest_accruals.day = $1
+		)
+		ORDER BY balance_interest.ledger_account_id
+	`
+
+	queryCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(queryCtx, query, day, end)
+	if err != nil {
+		return 0, err
+	}
+
+	targets, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (accrualTarget, error) {
+		var t accrualTarget
+		err := row.Scan(&t.accountID, &t.productID, &t.rate, &t.dayCount, &t.compounding)
+		return t, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	accrued := 0
+	for _, t := range targets {
+		inserted, err := m.accrue(ctx, t, day)
+		if err != nil {
+			return accrued, fmt.Errorf("accruing interest for ledger account %d: %w", t.accountID, err)
+		}
+		if inserted {
+			accrued++
+		}
+	}
+
+	return accrued, nil
+}
+
+func (m InterestModel) accrue(ctx context.Context, t accrualTarget, day time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// the balance at the end of the day comes from the postings rather than
+	// the snapshot, so running a day again later gives the same answer
+	query := `
+		SELECT COALESCE(SUM(postings.amount), 0)
+		FROM postings
+		INNER JOIN journal_entries ON journal_entries.id = postings.journal_entry_id
+		WHERE postings.ledger_account_id = $1 AND journal_entries.created_at < $2
+	`
+
+	var balance int64
+
+	err := m.DB.QueryRow(ctx, query, t.accountID, day.AddDate(0, 0, 1)).Scan(&balance)
+	if err != nil {
+		return false, err
+	}
+
+	base := new(big.Rat).SetInt64(balance)
+
+	if t.compounding == CompoundingDaily {
+		query = `
+			SELECT COALESCE(SUM(amount), 0)
+			FROM interest_accruals
+			WHERE ledger_account_id = $1 AND day >= $2 AND day < $3
+		`
+
+		var earlier pgtype.Numeric
+		monthStart := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		err = m.DB.QueryRow(ctx, query, t.accountID, monthStart, day).Scan(&earlier)
+		if err != nil {
+			return false, err
+		}
+
+		r, ok := numericRat(earlier)
+		if !ok {
+			return false, errors.New("invalid accrued interest")
+		}
+		base.Add(base, r)
+	}
+
+	// overdrawn balances don't earn anything, the zero accrual still marks
+	// the day as done
+	amount := new(big.Rat)
+	if base.Sign() > 0 {
+		amount.Mul(base, t.rate.Rat())
+		amount.Mul(amount, dayFraction(t.dayCount, day))
+	}
+
+	query = `
+		INSERT INTO interest_accruals (ledger_account_id, day, product_id, base, amount)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (ledger_account_id, day) DO NOTHING
+	`
+
+	args := []any{t.accountID, day, t.productID, ratNumeric(base, accrualScale), ratNumeric(amount, accrualScale)}
+
+	result, err := m.DB.Exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// Post pays out the interest accrued in the month and returns how many
+// balances it paid. Only whole minor units are paid, rounded half to even,
+// and what is left over is carried into the next month, as is all of it for a
+// frozen or closed account. A balance is posted at most once per month, so
+// this can be run again safely too.
+func (m InterestModel) Post(ctx context.Context, month time.Time) (int, error) {
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	query := `
+		SELECT DISTINCT interest_accruals.ledger_account_id
+		FROM interest_accruals
+		WHERE interest_accruals.day >= $1 AND interest_accruals.day < $2
+		AND NOT EXISTS (
+			SELECT 1 FROM interest_postings
+			WHERE interest_postings.ledger_account_id = interest_accruals.ledger_account_id
+			AND interest_postings.month = $1
+		)
+		ORDER BY interest_accruals.ledger_account_id
+	`
+
+	queryCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(queryCtx, query, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return 0, err
+	}
+
+	accountIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	for _, id := range accountIDs {
+		ok, err := m.post(ctx, id, month)
+		if err != nil {
+			return posted, fmt.Errorf("posting interest for ledger account %d: %w", id, err)
+		}
+		if ok {
+			posted++
+		}
+	}
+
+	return posted, nil
+}
+
+func (m InterestModel) post(ctx context.Context, accountID int64, month time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT COALESCE(SUM(amount), 0) + COALESCE((
+			SELECT accrued - posted
+			FROM interest_postings
+			WHERE ledger_account_id = $1 AND month < $2
+			ORDER BY month DESC
+			LIMIT 1
+		), 0)
+		FROM interest_accruals
+		WHERE ledger_account_id = $1 AND day >= $2 AND day < $3
+	`
+
+	var total pgtype.Numeric
+
+	err = tx.QueryRow(ctx, query, accountID, month, month.AddDate(0, 1, 0)).Scan(&total)
+	if err != nil {
+		return false, err
+	}
+
+	accrued, ok := numericRat(total)
+	if !ok {
+		return false, errors.New("invalid accrued interest")
+	}
+
+	amount := roundHalfEven(accrued)
+	if !amount.IsInt64() {
+		return false, ErrMoneyOverflow
+	}
+
+	// nothing is paid into a frozen or closed account, the interest is
+	// carried forward like the fractions of a minor unit are
+	err = checkAccountsActive(ctx, tx, []int64{accountID})
+	switch {
+	case errors.Is(err, ErrAccountNotActive):
+		amount.SetInt64(0)
+	case err != nil:
+		return false, err
+	}
+
+	// the primary key makes sure only one posting per month gets this far
+	query = `
+		INSERT INTO interest_postings (ledger_account_id, month, accrued, posted)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (ledger_account_id, month) DO NOTHING
+	`
+
+	result, err := tx.Exec(ctx, query, accountID, month, ratNumeric(accrued, accrualScale), amount.Int64())
+	if err != nil {
+		return false, err
+	}
+	if result.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if amount.Sign() > 0 {
+		var userID int64
+		var currency string
+
+		err = tx.QueryRow(ctx, `SELECT user_id, currency FROM ledger_accounts WHERE id = $1`, accountID).Scan(&userID, &currency)
+		if err != nil {
+			return false, err
+		}
+
+		bank, err := systemLedgerAccount(ctx, tx, LedgerAccountInterest, currency)
+		if err != nil {
+			return false, err
+		}
+
+		interest := NewMoney(amount.Int64(), currency)
+		description := "interest for " + month.Format("January 2006")
+
+		entry := &JournalEntry{
+			Description: description,
+			Postings: []Posting{
+				{LedgerAccountID: bank.ID, Amount: interest.Neg()},
+				{LedgerAccountID: accountID, Amount: interest},
+			},
+		}
+
+		err = postJournalEntry(ctx, tx, entry)
+		if err != nil {
+			return false, err
+		}
+
+		err = insertTransactions(ctx, tx, &Transaction{
+			UserID:         userID,
+			JournalEntryID: entry.ID,
+			Direction:      DirectionCredit,
+			Amount:         interest,
+			Description:    description,
+		})
+		if err != nil {
+			return false, err
+		}
+
+		query = `UPDATE interest_postings SET journal_entry_id = $1 WHERE ledger_account_id = $2 AND month = $3`
+
+		_, err = tx.Exec(ctx, query, entry.ID, accountID, month)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,603 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// name of the ledger account holding a user's spendable money
+const LedgerAccountBalance = "balance"
+
+var (
+	ErrUnbalancedEntry   = errors.New("journal entry must have at least two postings summing to zero per currency")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrPostingCurrency   = errors.New("posting currency doesn't match the ledger account")
+	ErrAccountNotActive  = errors.New("account is frozen or closed")
+)
+
+// LedgerAccount is owned by a user, or by the bank itself when UserID is nil.
+// Only the bank's accounts are allowed to go below zero.
+type LedgerAccount struct {
+	ID            int64     `json:"id"`
+	UserID        *int64    `json:"-"`
+	Name          string    `json:"name"`
+	Currency      string    `json:"currency"`
+	AllowNegative bool      `json:"-"`
+	Balance       Money     `json:"balance"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Posting moves Amount into a ledger account, a negative amount moves money
+// out of it
+type Posting struct {
+	ID              int64 `json:"id"`
+	JournalEntryID  int64 `json:"-"`
+	LedgerAccountID int64 `json:"ledger_account_id"`
+	Amount          Money `json:"amount"`
+}
+
+type JournalEntry struct {
+	ID          int64     `json:"id"`
+	Description string    `json:"description"`
+	Postings    []Posting `json:"postings"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Validate checks the double-entry rule, the same rule is enforced by a
+// deferred constraint trigger in the database
+func (e JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return ErrUnbalancedEntry
+	}
+
+	sums := make(map[string]Money)
+	for _, p := range e.Postings {
+		if p.Amount.IsZero() {
+			return ErrUnbalancedEntry
+		}
+
+		currency := p.Amount.Currency()
+		if _, ok := sums[currency]; !ok {
+			sums[currency] = NewMoney(0, currency)
+		}
+
+		sum, err := sums[currency].Add(p.Amount)
+		if err != nil {
+			return err
+		}
+		sums[currency] = sum
+	}
+
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return ErrUnbalancedEntry
+		}
+	}
+
+	return nil
+}
+
+// Balance is what a user has in one currency: the ledger balance counts every
+// posting, the available balance leaves out what holds reserve
+type Balance struct {
+	Currency         string `json:"currency"`
+	LedgerBalance    Money  `json:"ledger_balance"`
+	AvailableBalance Money  `json:"available_balance"`
+	Held             Money  `json:"held"`
+}
+
+// LedgerModel is an append-only double-entry ledger. Balances are derived
+// from the postings, ledger_balances keeps a snapshot of them that is updated
+// in the same transaction as every journal entry.
+type LedgerModel struct {
+	DB DBTX
+}
+
+func (m LedgerModel) CreateAccount(ctx context.Context, account *LedgerAccount) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO ledger_accounts (user_id, name, currency, allow_negative)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+
+	args := []any{account.UserID, account.Name, account.Currency, account.AllowNegative}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&account.ID, &account.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO ledger_balances (ledger_account_id) VALUES ($1);`, account.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (m LedgerModel) GetAccount(ctx context.Context, id int64) (*LedgerAccount, error) {
+	query := `
+		SELECT ledger_accounts.id, ledger_accounts.user_id, ledger_accounts.name, ledger_accounts.currency,
+			ledger_accounts.allow_negative, ledger_balances.balance, ledger_accounts.created_at
+		FROM ledger_accounts
+		INNER JOIN ledger_balances ON ledger_balances.ledger_account_id = ledger_accounts.id
+		WHERE ledger_accounts.id = $1
+	`
+
+	var account LedgerAccount
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := scanLedgerAccount(m.DB.QueryRow(ctx, query, id), &account)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &account, nil
+}
+
+func (m LedgerModel) GetAccountsForUser(ctx context.Context, userID int64) ([]*LedgerAccount, error) {
+	query := `
+		SELECT ledger_accounts.id, ledger_accounts.user_id, ledger_accounts.name, ledger_accounts.currency,
+			ledger_accounts.allow_negative, ledger_balances.balance, ledger_accounts.created_at
+		FROM ledger_accounts
+		INNER JOIN ledger_balances ON ledger_balances.ledger_account_id = ledger_accounts.id
+		WHERE ledger_accounts.user_id = $1
+		ORDER BY ledger_accounts.currency, ledger_accounts.name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*LedgerAccount{}
+
+	for rows.Next() {
+		var account LedgerAccount
+
+		err := scanLedgerAccount(rows, &account)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, &account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// balances are stored in minor units, the account's currency says of what
+func scanLedgerAccount(row pgx.Row, account *LedgerAccount) error {
+	var balance int64
+
+	err := row.Scan(
+		&account.ID,
+		&account.UserID,
+		&account.Name,
+		&account.Currency,
+		&account.AllowNegative,
+		&balance,
+		&account.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	account.Balance = NewMoney(balance, account.Currency)
+	return nil
+}
+
+// UserAccount returns the user's balance account in the currency, opening it
+// on first use
+func (m LedgerModel) UserAccount(ctx context.Context, userID int64, currency string) (*LedgerAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	account, err := userLedgerAccount(ctx, tx, userID, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, tx.Commit(ctx)
+}
+
+// SystemAccount returns one of the bank's own accounts, like the one money
+// is paid in from, opening it on first use
+func (m LedgerModel) SystemAccount(ctx context.Context, name, currency string) (*LedgerAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	account, err := systemLedgerAccount(ctx, tx, name, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, tx.Commit(ctx)
+}
+
+func userLedgerAccount(ctx context.Context, tx pgx.Tx, userID int64, currency string) (*LedgerAccount, error) {
+	query := `
+		INSERT INTO ledger_accounts (user_id, name, currency, allow_negative)
+		VALUES ($1, $2, $3, false)
+		ON CONFLICT (user_id, name, currency) WHERE user_id IS NOT NULL DO NOTHING
+		RETURNING id
+	`
+
+	return openLedgerAccount(ctx, tx, query, &userID, LedgerAccountBalance, currency)
+}
+
+func systemLedgerAccount(ctx context.Context, tx pgx.Tx, name, currency string) (*LedgerAccount, error) {
+	query := `
+		INSERT INTO ledger_accounts (user_id, name, currency, allow_negative)
+		VALUES ($1, $2, $3, true)
+		ON CONFLICT (name, currency) WHERE user_id IS NULL DO NOTHING
+		RETURNING id
+	`
+
+	return openLedgerAccount(ctx, tx, query, nil, name, currency)
+}
+
+func openLedgerAccount(ctx context.Context, tx pgx.Tx, insert string, userID *int64, name, currency string) (*LedgerAccount, error) {
+	var id int64
+
+	err := tx.QueryRow(ctx, insert, userID, name, currency).Scan(&id)
+	switch {
+	case err == nil:
+		_, err = tx.Exec(ctx, `INSERT INTO ledger_balances (ledger_account_id) VALUES ($1);`, id)
+		if err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, err
+	}
+
+	query := `
+		SELECT ledger_accounts.id, ledger_accounts.user_id, ledger_accounts.name, ledger_accounts.currency,
+			ledger_accounts.allow_negative, ledger_balances.balance, ledger_accounts.created_at
+		FROM ledger_accounts
+		INNER JOIN ledger_balances ON ledger_balances.ledger_account_id = ledger_accounts.id
+		WHERE ledger_accounts.user_id IS NOT DISTINCT FROM $1
+		AND ledger_accounts.name = $2
+		AND ledger_accounts.currency = $3
+	`
+
+	var account LedgerAccount
+
+	err = scanLedgerAccount(tx.QueryRow(ctx, query, userID, name, currency), &account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// Post records the journal entry and updates the balance snapshots of every
+// account it touches, all in one transaction
+func (m LedgerModel) Post(ctx context.Context, entry *JournalEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = postJournalEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// postJournalEntry does the work of Post inside a transaction owned by the
+// caller, so other models can combine a journal entry with their own writes
+func postJournalEntry(ctx context.Context, tx pgx.Tx, entry *JournalEntry) error {
+	err := entry.Validate()
+	if err != nil {
+		return err
+	}
+
+	deltas := make(map[int64]int64)
+	currencies := make(map[int64]string)
+	var accountIDs []int64
+
+	for _, p := range entry.Postings {
+		if _, ok := deltas[p.LedgerAccountID]; !ok {
+			accountIDs = append(accountIDs, p.LedgerAccountID)
+		}
+		deltas[p.LedgerAccountID] += p.Amount.Amount()
+		currencies[p.LedgerAccountID] = p.Amount.Currency()
+	}
+
+	// locking the balances in id order means two entries touching the same
+	// accounts can't deadlock each other
+	slices.Sort(accountIDs)
+
+	query := `
+		SELECT ledger_accounts.id, ledger_accounts.currency, ledger_accounts.allow_negative, ledger_balances.balance, ledger_balances.held
+		FROM ledger_balances
+		INNER JOIN ledger_accounts ON ledger_accounts.id = ledger_balances.ledger_account_id
+		WHERE ledger_balances.ledger_account_id = ANY($1)
+		ORDER BY ledger_balances.ledger_account_id
+		FOR UPDATE OF ledger_balances
+	`
+
+	rows, err := tx.Query(ctx, query, accountIDs)
+	if err != nil {
+		return err
+	}
+
+	locked := 0
+	for rows.Next() {
+		var id, balance, held int64
+		var currency string
+		var allowNegative bool
+
+		err := rows.Scan(&id, &currency, &allowNegative, &balance, &held)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		switch {
+		case currency != currencies[id]:
+			rows.Close()
+			return ErrPostingCurrency
+		// money reserved by holds can't be spent, but a hold never stops
+		// money coming in
+		case !allowNegative && deltas[id] < 0 && balance-held+deltas[id] < 0:
+			rows.Close()
+			return ErrInsufficientFunds
+		}
+
+		locked++
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if locked != len(accountIDs) {
+		return fmt.Errorf("posting to ledger account: %w", ErrRecordNotFound)
+	}
+
+	// checked once the balances are locked, so an account being closed
+	// concurrently is either seen closed here or waits for this entry
+	err = checkAccountsActive(ctx, tx, accountIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range entry.Postings {
+		if currencies[p.LedgerAccountID] != p.Amount.Currency() {
+			return ErrPostingCurrency
+		}
+	}
+
+	query = `
+		INSERT INTO journal_entries (description)
+		VALUES ($1)
+		RETURNING id, created_at;
+	`
+
+	err = tx.QueryRow(ctx, query, entry.Description).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO postings (journal_entry_id, ledger_account_id, amount, currency)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+	`
+
+	lastPosting := make(map[int64]int64)
+
+	for i := range entry.Postings {
+		p := &entry.Postings[i]
+		p.JournalEntryID = entry.ID
+
+		err = tx.QueryRow(ctx, query, p.JournalEntryID, p.LedgerAccountID, p.Amount.Amount(), p.Amount.Currency()).Scan(&p.ID)
+		if err != nil {
+			return err
+		}
+
+		lastPosting[p.LedgerAccountID] = p.ID
+	}
+
+	query = `
+		UPDATE ledger_balances
+		SET balance = balance + $2, last_posting_id = GREATEST(last_posting_id, $3), updated_at = NOW()
+		WHERE ledger_account_id = $1;
+	`
+
+	for _, id := range accountIDs {
+		_, err = tx.Exec(ctx, query, id, deltas[id], lastPosting[id])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkAccountsActive returns ErrAccountNotActive when any of the ledger
+// accounts only backs accounts that are frozen or closed. Ledger accounts no
+// account is linked to, like the bank's own, are left alone.
+func checkAccountsActive(ctx context.Context, tx pgx.Tx, ledgerAccountIDs []int64) error {
+	query := `
+		SELECT ledger_account_id
+		FROM accounts
+		WHERE ledger_account_id = ANY($1)
+		GROUP BY ledger_account_id
+		HAVING bool_and(status <> 'active')
+		LIMIT 1
+	`
+
+	var id int64
+
+	err := tx.QueryRow(ctx, query, ledgerAccountIDs).Scan(&id)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	return ErrAccountNotActive
+}
+
+// Balance derives the balance from the snapshot plus any postings made after it
+func (m LedgerModel) Balance(ctx context.Context, accountID int64) (Money, error) {
+	query := `
+		SELECT ledger_accounts.currency, ledger_balances.balance + COALESCE((
+			SELECT SUM(postings.amount)
+			FROM postings
+			WHERE postings.ledger_account_id = ledger_balances.ledger_account_id
+			AND postings.id > ledger_balances.last_posting_id
+		), 0)
+		FROM ledger_balances
+		INNER JOIN ledger_accounts ON ledger_accounts.id = ledger_balances.ledger_account_id
+		WHERE ledger_balances.ledger_account_id = $1
+	`
+
+	var currency string
+	var balance int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, accountID).Scan(&currency, &balance)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return Money{}, ErrRecordNotFound
+		default:
+			return Money{}, err
+		}
+	}
+
+	return NewMoney(balance, currency), nil
+}
+
+// VerifyBalance recomputes the balance from every posting of the account and
+// compares it with the snapshot, a mismatch means the snapshot has drifted
+func (m LedgerModel) VerifyBalance(ctx context.Context, accountID int64) (snapshot, computed Money, err error) {
+	query := `
+		SELECT ledger_accounts.currency, ledger_balances.balance, COALESCE((
+			SELECT SUM(postings.amount)
+			FROM postings
+			WHERE postings.ledger_account_id = ledger_balances.ledger_account_id
+		), 0)
+		FROM ledger_balances
+		INNER JOIN ledger_accounts ON ledger_accounts.id = ledger_balances.ledger_account_id
+		WHERE ledger_balances.ledger_account_id = $1
+	`
+
+	var currency string
+	var stored, sum int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRow(ctx, query, accountID).Scan(&currency, &stored, &sum)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return Money{}, Money{}, ErrRecordNotFound
+		default:
+			return Money{}, Money{}, err
+		}
+	}
+
+	return NewMoney(stored, currency), NewMoney(sum, currency), nil
+}
+
+// BalancesForUser returns the user's balance in every currency they hold
+func (m LedgerModel) BalancesForUser(ctx context.Context, userID int64) ([]*Balance, error) {
+	query := `
+		SELECT ledger_accounts.currency, ledger_balances.balance, ledger_balances.held
+		FROM ledger_accounts
+		INNER JOIN ledger_balances ON ledger_balances.ledger_account_id = ledger_accounts.id
+		WHERE ledger_accounts.user_id = $1 AND ledger_accounts.name = $2
+		ORDER BY ledger_accounts.currency
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID, LedgerAccountBalance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []*Balance{}
+
+	for rows.Next() {
+		var currency string
+		var balance, held int64
+
+		err := rows.Scan(&currency, &balance, &held)
+		if err != nil {
+			return nil, err
+		}
+
+		balances = append(balances, &Balance{
+			Currency:         currency,
+			LedgerBalance:    NewMoney(balance, currency),
+			AvailableBalance: NewMoney(balance-held, currency),
+			Held:             NewMoney(held, currency),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
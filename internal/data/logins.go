@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LoginStats summarises the failed logins of an account or a client IP
+type LoginStats struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Backoff returns how long the next attempt has to wait after the last
+// failure, doubling with every failure up to max.
+func (s LoginStats) Backoff(base, max time.Duration) time.Duration {
+	if s.Failures == 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < s.Failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	return min(delay, max)
+}
+
+type LoginAttemptRepository interface {
+	RecordFailure(ctx context.Context, userID *int64, ip string) error
+	ForUser(ctx context.Context, userID int64, since time.Time) (LoginStats, error)
+	ForIP(ctx context.Context, ip string, since time.Time) (LoginStats, error)
+	ClearForUser(ctx context.Context, userID int64) error
+	Lock(ctx context.Context, userID int64, until time.Time) error
+	LockedUntil(ctx context.Context, userID int64) (time.Time, error)
+}
+
+type LoginAttemptModel struct {
+	DB DBTX
+}
+
+// RecordFailure stores a failed attempt, userID is nil when the email didn't
+// belong to any account
+func (m LoginAttemptModel) RecordFailure(ctx context.Context, userID *int64, ip string) error {
+	query := `
+		INSERT INTO failed_logins (user_id, ip)
+		VALUES ($1, $2);
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, ip)
+	return err
+}
+
+func (m LoginAttemptModel) ForUser(ctx context.Context, userID int64, since time.Time) (LoginStats, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(created_at), 'epoch')
+		FROM failed_logins
+		WHERE user_id = $1 AND created_at > $2;
+	`
+
+	return m.stats(ctx, query, userID, since)
+}
+
+func (m LoginAttemptModel) ForIP(ctx context.Context, ip string, since time.Time) (LoginStats, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(created_at), 'epoch')
+		FROM failed_logins
+		WHERE ip = $1 AND created_at > $2;
+	`
+
+	return m.stats(ctx, query, ip, since)
+}
+
+func (m LoginAttemptModel) stats(ctx context.Context, query string, args ...any) (LoginStats, error) {
+	var stats LoginStats
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&stats.Failures, &stats.LastFailure)
+	return stats, err
+}
+
+func (m LoginAttemptModel) ClearForUser(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM failed_logins
+		WHERE user_id = $1;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
+
+func (m LoginAttemptModel) Lock(ctx context.Context, userID int64, until time.Time) error {
+	query := `
+		INSERT INTO account_lockouts (user_id, locked_until)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET locked_until = EXCLUDED.locked_until;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, until)
+	return err
+}
+
+// LockedUntil returns the zero time when the account isn't locked
+func (m LoginAttemptModel) LockedUntil(ctx context.Context, userID int64) (time.Time, error) {
+	query := `
+		SELECT locked_until
+		FROM account_lockouts
+		WHERE user_id = $1;
+	`
+
+	var lockedUntil time.Time
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return time.Time{}, nil
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return lockedUntil, nil
+}
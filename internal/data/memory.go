@@ -0,0 +1,531 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryModels returns models that keep users, tokens, permissions and
+// failed logins in memory instead of Postgres, for testing handlers. They
+// return the same errors as the Postgres models. Only the given roles exist,
+// along with the permission codes they bundle. Revocations only has its
+// cache, the other models aren't backed by anything and must not be used.
+func NewMemoryModels(roles ...*Role) Models {
+	store := &memoryStore{
+		users:           make(map[int64]*Users),
+		permissions:     make(map[string]bool),
+		roles:           make(map[string]Permissions),
+		userPermissions: make(map[int64]map[string]bool),
+		userRoles:       make(map[int64]map[string]bool),
+		recoveryCodes:   make(map[int64][]*memoryRecoveryCode),
+		lockouts:        make(map[int64]time.Time),
+	}
+
+	for _, role := range roles {
+		store.roles[role.Name] = slices.Clone(role.Permissions)
+		for _, code := range role.Permissions {
+			store.permissions[code] = true
+		}
+	}
+
+	return Models{
+		Users:         memoryUsers{store},
+		Permissions:   memoryPermissions{store},
+		Tokens:        memoryTokens{store},
+		LoginAttempts: memoryLoginAttempts{store},
+		Revocations:   RevocationModel{cache: newRevocationCache()},
+	}
+}
+
+// memoryStore is shared by all the in-memory models, a single lock keeps
+// things like GetForToken, which reads users and tokens, consistent
+type memoryStore struct {
+	mu sync.Mutex
+
+	users      map[int64]*Users
+	nextUserID int64
+
+	tokens []*memoryToken
+
+	permissions     map[string]bool
+	roles           map[string]Permissions
+	userPermissions map[int64]map[string]bool
+	userRoles       map[int64]map[string]bool
+
+	recoveryCodes map[int64][]*memoryRecoveryCode
+
+	failedLogins []memoryFailedLogin
+	lockouts     map[int64]time.Time
+}
+
+type memoryToken struct {
+	Token
+	used bool
+}
+
+type memoryRecoveryCode struct {
+	hash []byte
+	used bool
+}
+
+type memoryFailedLogin struct {
+	userID    *int64
+	ip        string
+	createdAt time.Time
+}
+
+// the users table has the email as citext, so lookups ignore case
+func (s *memoryStore) userByEmail(email string) *Users {
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+type memoryUsers struct {
+	store *memoryStore
+}
+
+// users are copied in and out so callers can't change the stored ones
+// without going through Update
+func (m memoryUsers) Get(ctx context.Context, id int64) (*Users, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, ok := m.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	u := *user
+	return &u, nil
+}
+
+func (m memoryUsers) Insert(ctx context.Context, user *Users) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.userByEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	m.store.nextUserID++
+	user.ID = m.store.nextUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	u := *user
+	m.store.users[u.ID] = &u
+
+	return nil
+}
+
+func (m memoryUsers) GetUserByEmail(ctx context.Context, email string) (*Users, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user := m.store.userByEmail(email)
+	if user == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	u := *user
+	return &u, nil
+}
+
+func (m memoryUsers) Update(ctx context.Context, user *Users) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	current, ok := m.store.users[user.ID]
+	if !ok || current.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if other := m.store.userByEmail(user.Email); other != nil && other.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+
+	u := *user
+	m.store.users[u.ID] = &u
+
+	return nil
+}
+
+func (m memoryUsers) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*Users, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, t := range m.store.tokens {
+		if bytes.Equal(t.Hash, hash[:]) && t.Scope == tokenScope && t.Expiry.After(time.Now()) {
+			user, ok := m.store.users[t.UserID]
+			if !ok {
+				break
+			}
+
+			u := *user
+			return &u, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUsers) SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	recoveryCodes := make([]*memoryRecoveryCode, len(codes))
+	for i, code := range codes {
+		recoveryCodes[i] = &memoryRecoveryCode{hash: hashRecoveryCode(code)}
+	}
+
+	m.store.recoveryCodes[userID] = recoveryCodes
+
+	return nil
+}
+
+func (m memoryUsers) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	hash := hashRecoveryCode(code)
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, c := range m.store.recoveryCodes[userID] {
+		if !c.used && bytes.Equal(c.hash, hash) {
+			c.used = true
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+type memoryTokens struct {
+	store *memoryStore
+}
+
+func (m memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) NewRefresh(ctx context.Context, userID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = make([]byte, 16)
+	_, err = rand.Read(token.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) Insert(ctx context.Context, token *Token) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.tokens = append(m.store.tokens, &memoryToken{Token: *token})
+
+	return nil
+}
+
+func (m memoryTokens) Rotate(ctx context.Context, tokenPlaintext string, ttl time.Duration) (*Token, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	i := slices.IndexFunc(m.store.tokens, func(t *memoryToken) bool {
+		return bytes.Equal(t.Hash, hash[:]) && t.Scope == ScopeRefresh
+	})
+	if i < 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	current := m.store.tokens[i]
+
+	if current.used {
+		m.deleteFamily(current.Family)
+		return nil, ErrTokenReused
+	}
+
+	if current.Expiry.Before(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	current.used = true
+
+	token, err := generateToken(current.UserID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = current.Family
+
+	m.store.tokens = append(m.store.tokens, &memoryToken{Token: *token})
+
+	return token, nil
+}
+
+func (m memoryTokens) DeleteForUser(ctx context.Context, scope string, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.tokens = slices.DeleteFunc(m.store.tokens, func(t *memoryToken) bool {
+		return t.Scope == scope && t.UserID == userID
+	})
+
+	return nil
+}
+
+func (m memoryTokens) DeleteFamily(ctx context.Context, family []byte) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.deleteFamily(family)
+
+	return nil
+}
+
+// callers hold the lock
+func (m memoryTokens) deleteFamily(family []byte) {
+	m.store.tokens = slices.DeleteFunc(m.store.tokens, func(t *memoryToken) bool {
+		return t.Family != nil && bytes.Equal(t.Family, family)
+	})
+}
+
+type memoryPermissions struct {
+	store *memoryStore
+}
+
+func (m memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	codes := make(map[string]bool)
+	for code := range m.store.userPermissions[userID] {
+		codes[code] = true
+	}
+	for role := range m.store.userRoles[userID] {
+		for _, code := range m.store.roles[role] {
+			codes[code] = true
+		}
+	}
+
+	return sortedKeys(codes), nil
+}
+
+func (m memoryPermissions) GetDirectForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return sortedKeys(m.store.userPermissions[userID]), nil
+}
+
+func (m memoryPermissions) GetAll(ctx context.Context) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return sortedKeys(m.store.permissions), nil
+}
+
+func (m memoryPermissions) Insert(ctx context.Context, code string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.permissions[code] {
+		return ErrDuplicatePermission
+	}
+
+	m.store.permissions[code] = true
+
+	return nil
+}
+
+func (m memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		if !m.store.permissions[code] {
+			return ErrUnknownPermission
+		}
+	}
+
+	addToSet(m.store.userPermissions, userID, codes)
+
+	return nil
+}
+
+func (m memoryPermissions) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		delete(m.store.userPermissions[userID], code)
+	}
+
+	return nil
+}
+
+func (m memoryPermissions) GetAllRoles(ctx context.Context) ([]*Role, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	roles := []*Role{}
+	for name, permissions := range m.store.roles {
+		permissions = slices.Clone(permissions)
+		slices.Sort(permissions)
+		roles = append(roles, &Role{Name: name, Permissions: permissions})
+	}
+
+	slices.SortFunc(roles, func(a, b *Role) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return roles, nil
+}
+
+func (m memoryPermissions) AddRolesForUser(ctx context.Context, userID int64, roles ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, role := range roles {
+		if _, ok := m.store.roles[role]; !ok {
+			return ErrUnknownRole
+		}
+	}
+
+	addToSet(m.store.userRoles, userID, roles)
+
+	return nil
+}
+
+func (m memoryPermissions) RemoveRolesForUser(ctx context.Context, userID int64, roles ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, role := range roles {
+		delete(m.store.userRoles[userID], role)
+	}
+
+	return nil
+}
+
+func (m memoryPermissions) GetRolesForUser(ctx context.Context, userID int64) ([]string, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return sortedKeys(m.store.userRoles[userID]), nil
+}
+
+func addToSet(sets map[int64]map[string]bool, userID int64, values []string) {
+	if sets[userID] == nil {
+		sets[userID] = make(map[string]bool)
+	}
+	for _, v := range values {
+		sets[userID][v] = true
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+type memoryLoginAttempts struct {
+	store *memoryStore
+}
+
+func (m memoryLoginAttempts) RecordFailure(ctx context.Context, userID *int64, ip string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.failedLogins = append(m.store.failedLogins, memoryFailedLogin{userID: userID, ip: ip, createdAt: time.Now()})
+
+	return nil
+}
+
+func (m memoryLoginAttempts) ForUser(ctx context.Context, userID int64, since time.Time) (LoginStats, error) {
+	return m.stats(since, func(f memoryFailedLogin) bool {
+		return f.userID != nil && *f.userID == userID
+	}), nil
+}
+
+func (m memoryLoginAttempts) ForIP(ctx context.Context, ip string, since time.Time) (LoginStats, error) {
+	return m.stats(since, func(f memoryFailedLogin) bool {
+		return f.ip == ip
+	}), nil
+}
+
+func (m memoryLoginAttempts) stats(since time.Time, match func(memoryFailedLogin) bool) LoginStats {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// same as COALESCE(MAX(created_at), 'epoch')
+	stats := LoginStats{LastFailure: time.Unix(0, 0)}
+
+	for _, f := range m.store.failedLogins {
+		if f.createdAt.After(since) && match(f) {
+			stats.Failures++
+			stats.LastFailure = f.createdAt
+		}
+	}
+
+	return stats
+}
+
+func (m memoryLoginAttempts) ClearForUser(ctx context.Context, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.failedLogins = slices.DeleteFunc(m.store.failedLogins, func(f memoryFailedLogin) bool {
+		return f.userID != nil && *f.userID == userID
+	})
+
+	return nil
+}
+
+func (m memoryLoginAttempts) Lock(ctx context.Context, userID int64, until time.Time) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lockouts[userID] = until
+
+	return nil
+}
+
+func (m memoryLoginAttempts) LockedUntil(ctx context.Context, userID int64) (time.Time, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return m.store.lockouts[userID], nil
+}
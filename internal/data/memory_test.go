@@ -0,0 +1,191 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newMemoryUser(t *testing.T, m Models, email string) *Users {
+	t.Helper()
+
+	user := &Users{Username: "test", Email: email}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestMemoryUsers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+
+	user := newMemoryUser(t, m, "alice@example.com")
+	if user.ID == 0 || user.Version != 1 {
+		t.Fatalf("got id %d version %d after insert", user.ID, user.Version)
+	}
+
+	err := m.Users.Insert(ctx, &Users{Username: "other", Email: "Alice@Example.com"})
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("inserting a duplicate email: got %v, want ErrDuplicateEmail", err)
+	}
+
+	stale, err := m.Users.Get(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Activated = true
+	err = m.Users.Update(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Username = "changed"
+	err = m.Users.Update(ctx, stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("updating a stale copy: got %v, want ErrEditConflict", err)
+	}
+
+	other := newMemoryUser(t, m, "bob@example.com")
+	other.Email = "alice@example.com"
+	err = m.Users.Update(ctx, other)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("updating to a taken email: got %v, want ErrDuplicateEmail", err)
+	}
+
+	_, err = m.Users.Get(ctx, 1000)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("getting a missing user: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryTokens(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+
+	user := newMemoryUser(t, m, "alice@example.com")
+
+	valid, err := m.Tokens.New(ctx, user.ID, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := m.Tokens.New(ctx, user.ID, -time.Second, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Users.GetForToken(ctx, ScopeActivation, valid.Token)
+	if err != nil || got.ID != user.ID {
+		t.Errorf("valid token: got %v, %v", got, err)
+	}
+
+	_, err = m.Users.GetForToken(ctx, ScopeActivation, expired.Token)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expired token: got %v, want ErrRecordNotFound", err)
+	}
+
+	_, err = m.Users.GetForToken(ctx, ScopePasswordReset, valid.Token)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("token used for another scope: got %v, want ErrRecordNotFound", err)
+	}
+
+	err = m.Tokens.DeleteForUser(ctx, ScopeActivation, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Users.GetForToken(ctx, ScopeActivation, valid.Token)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("deleted token: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryTokensRotate(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+
+	first, err := m.Tokens.NewRefresh(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := m.Tokens.Rotate(ctx, first.Token, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Tokens.Rotate(ctx, first.Token, time.Hour)
+	if !errors.Is(err, ErrTokenReused) {
+		t.Errorf("rotating twice: got %v, want ErrTokenReused", err)
+	}
+
+	_, err = m.Tokens.Rotate(ctx, second.Token, time.Hour)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("rotating after the family was revoked: got %v, want ErrRecordNotFound", err)
+	}
+
+	expired, err := m.Tokens.NewRefresh(ctx, 1, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Tokens.Rotate(ctx, expired.Token, time.Hour)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("rotating an expired token: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryPermissions(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels(&Role{Name: "customer", Permissions: Permissions{"accounts:read"}})
+
+	err := m.Permissions.AddRolesForUser(ctx, 1, "customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Permissions.AddRolesForUser(ctx, 1, "nope")
+	if !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("adding an unknown role: got %v, want ErrUnknownRole", err)
+	}
+
+	err = m.Permissions.AddForUser(ctx, 1, "transfers:write")
+	if !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("adding an unknown permission: got %v, want ErrUnknownPermission", err)
+	}
+
+	err = m.Permissions.Insert(ctx, "transfers:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Permissions.Insert(ctx, "transfers:write")
+	if !errors.Is(err, ErrDuplicatePermission) {
+		t.Errorf("inserting a permission twice: got %v, want ErrDuplicatePermission", err)
+	}
+
+	err = m.Permissions.AddForUser(ctx, 1, "transfers:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err := m.Permissions.GetAllForUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !permissions.Include("accounts:read") || !permissions.Include("transfers:write") {
+		t.Errorf("got effective permissions %v", permissions)
+	}
+}
@@ -0,0 +1,104 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"bankapi/internal/totp"
+)
+
+const (
+	ScopeMFA               = "mfa"
+	ScopeMFAAuthentication = "mfa-authentication"
+)
+
+// VerifyTOTP checks a code from the user's authenticator app. A code is only
+// accepted once, the caller has to persist TOTPLastStep with Update for that
+// to hold across requests.
+func (u *Users) VerifyTOTP(code string) bool {
+	step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+	if ok {
+		u.TOTPLastStep = step
+	}
+
+	return ok
+}
+
+// GenerateRecoveryCodes returns n random codes formatted as XXXXX-XXXXX
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// SetRecoveryCodes replaces every recovery code of the user, only the hashes are stored
+func (m UserModel) SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO recovery_codes (hash, user_id)
+		VALUES ($1, $2);
+	`
+
+	for _, code := range codes {
+		_, err = tx.Exec(ctx, query, hashRecoveryCode(code), userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseRecoveryCode marks the code as used and reports whether it was valid
+func (m UserModel) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
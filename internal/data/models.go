@@ -1,19 +1,143 @@
 package data
 
 import (
+	"context"
+
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// maxTxAttempts is how often WithTx runs a transaction that keeps losing
+// serialization races before giving up
+const maxTxAttempts = 3
+
+// DBTX is what the models need from the database, satisfied by both
+// *pgxpool.Pool and pgx.Tx. Begin on a pgx.Tx starts a savepoint, so models
+// that use transactions of their own still work inside WithTx
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Models struct {
-	Users       UserModel
-	Permissions PermissionsModel
-	Tokens      TokenModel
+	Users          UserRepository
+	Permissions    PermissionsRepository
+	Tokens         TokenRepository
+	Revocations    RevocationModel
+	LoginAttempts  LoginAttemptRepository
+	EmailChanges   EmailChangeModel
+	Audit          AuditModel
+	Accounts       AccountModel
+	Ledger         LedgerModel
+	Transfers      TransferModel
+	Idempotency    IdempotencyModel
+	FXRates        FXRateModel
+	FXQuotes       FXQuoteModel
+	Transactions   TransactionModel
+	Holds          HoldModel
+	StandingOrders StandingOrderModel
+	Interest       InterestModel
+
+	db          DBTX
+	revocations *revocationCache
+	secrets     *SecretBox
+}
+
+// NewModel returns the Postgres models, secrets encrypts the TOTP secrets and
+// may be nil to store them unencrypted
+func NewModel(db DBTX, secrets *SecretBox) Models {
+	return newModels(db, newRevocationCache(), secrets)
 }
 
-func NewModel(db *pgx.Conn) Models {
+func newModels(db DBTX, revocations *revocationCache, secrets *SecretBox) Models {
 	return Models{
-		Users:       UserModel{DB: db},
-		Permissions: PermissionsModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Users:          UserModel{DB: db, secrets: secrets},
+		Permissions:    PermissionsModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Revocations:    RevocationModel{DB: db, cache: revocations},
+		LoginAttempts:  LoginAttemptModel{DB: db},
+		EmailChanges:   EmailChangeModel{DB: db},
+		Audit:          AuditModel{DB: db},
+		Accounts:       AccountModel{DB: db},
+		Ledger:         LedgerModel{DB: db},
+		Transfers:      TransferModel{DB: db},
+		Idempotency:    IdempotencyModel{DB: db},
+		FXRates:        FXRateModel{DB: db},
+		FXQuotes:       FXQuoteModel{DB: db},
+		Transactions:   TransactionModel{DB: db},
+		Holds:          HoldModel{DB: db},
+		StandingOrders: StandingOrderModel{DB: db},
+		Interest:       InterestModel{DB: db},
+
+		db:          db,
+		revocations: revocations,
+		secrets:     secrets,
+	}
+}
+
+// WithTx runs fn with models bound to a single transaction, committing if fn
+// returns nil and rolling back otherwise. Transactions that fail because of a
+// serialization failure or deadlock are retried from the start, so fn must not
+// have side effects outside the database. Calling WithTx on models that are
+// already inside a transaction runs fn in a savepoint instead.
+//
+// Models from NewMemoryModels have no transactions, fn is simply called.
+func (m Models) WithTx(ctx context.Context, iso pgx.TxIsoLevel, fn func(tx Models) error) error {
+	// the in-memory models have nothing to roll back
+	if m.db == nil {
+		return fn(m)
+	}
+
+	return retryTx(ctx, m.db, iso, func(tx pgx.Tx) error {
+		return fn(newModels(tx, m.revocations, m.secrets))
+	})
+}
+
+// retryTx runs fn in a transaction on db and starts over when it loses a
+// serialization race, returning ErrEditConflict once maxTxAttempts have all
+// lost. On a db that is a transaction already fn runs once in a savepoint,
+// retrying is up to whoever started the outer transaction.
+func retryTx(ctx context.Context, db DBTX, iso pgx.TxIsoLevel, fn func(tx pgx.Tx) error) error {
+	if _, nested := db.(pgx.Tx); nested {
+		return runTx(ctx, db, iso, fn)
+	}
+
+	var err error
+	for range maxTxAttempts {
+		err = runTx(ctx, db, iso, fn)
+		if !isSerializationFailure(err) {
+			return err
+		}
+	}
+
+	return ErrEditConflict
+}
+
+func runTx(ctx context.Context, db DBTX, iso pgx.TxIsoLevel, fn func(tx pgx.Tx) error) error {
+	tx, err := beginTx(ctx, db, pgx.TxOptions{IsoLevel: iso})
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback(ctx)
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// beginTx starts a transaction with the given options, or a savepoint when db
+// is a transaction already, in which case the outer isolation level applies
+func beginTx(ctx context.Context, db DBTX, opts pgx.TxOptions) (pgx.Tx, error) {
+	if b, ok := db.(interface {
+		BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+	}); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	return db.Begin(ctx)
 }
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeTx only supports what retryTx needs, anything else panics on the nil
+// embedded Tx
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (tx *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return tx.db.Begin(ctx)
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.db.commits++
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	return nil
+}
+
+type fakeDB struct {
+	DBTX
+	commits int
+}
+
+func (db *fakeDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &fakeTx{db: db}, nil
+}
+
+func TestRetryTx(t *testing.T) {
+	serializationFailure := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	otherFailure := errors.New("boom")
+
+	tests := []struct {
+		name        string
+		nested      bool
+		failures    []error
+		wantCalls   int
+		wantCommits int
+		wantErr     error
+	}{
+		{name: "first time", wantCalls: 1, wantCommits: 1},
+		{name: "after losing races", failures: []error{serializationFailure, serializationFailure}, wantCalls: 3, wantCommits: 1},
+		{name: "losing every race", failures: []error{serializationFailure, serializationFailure, serializationFailure}, wantCalls: 3, wantErr: ErrEditConflict},
+		{name: "other errors aren't retried", failures: []error{otherFailure}, wantCalls: 1, wantErr: otherFailure},
+		{name: "nested", nested: true, failures: []error{serializationFailure}, wantCalls: 1, wantErr: serializationFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+
+			var conn DBTX = db
+			if tt.nested {
+				conn = &fakeTx{db: db}
+			}
+
+			calls := 0
+			err := retryTx(context.Background(), conn, pgx.Serializable, func(tx pgx.Tx) error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fn ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if db.commits != tt.wantCommits {
+				t.Errorf("committed %d times, want %d", db.commits, tt.wantCommits)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("money: currencies don't match")
+	ErrMoneyOverflow    = errors.New("money: amount out of range")
+	ErrMoneyPrecision   = errors.New("money: too many decimal places for the currency")
+	ErrInvalidMoney     = errors.New("money: invalid amount")
+)
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit isn't a
+// hundredth, every other currency has two decimal places
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// CurrencyExponent returns the number of decimal places of the currency
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Money is an exact amount of a currency, kept as an integer number of minor
+// units (cents for USD, yen for JPY) so it never picks up float errors
+type Money struct {
+	amount   int64
+	currency string
+}
+
+// NewMoney returns amount minor units of the currency
+func NewMoney(amount int64, currency string) Money {
+	return Money{amount: amount, currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney reads a decimal string like "-12.34" in the currency's major units
+func ParseMoney(amount, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	exp := CurrencyExponent(currency)
+
+	s, negative := strings.CutPrefix(amount, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+
+	if whole == "" || strings.ContainsAny(whole+frac, "+-") || strings.Contains(s, ".") && frac == "" {
+		return Money{}, ErrInvalidMoney
+	}
+
+	// trailing zeros past the currency's precision don't change the amount
+	trimmed := strings.TrimRight(frac, "0")
+	if len(trimmed) > exp {
+		return Money{}, ErrMoneyPrecision
+	}
+	frac = trimmed + strings.Repeat("0", exp-len(trimmed))
+
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return Money{}, ErrMoneyOverflow
+		}
+		return Money{}, ErrInvalidMoney
+	}
+
+	if negative {
+		n = -n
+	}
+
+	return Money{amount: n, currency: currency}, nil
+}
+
+// Amount returns the amount in minor units
+func (m Money) Amount() int64 {
+	return m.amount
+}
+
+func (m Money) Currency() string {
+	return m.currency
+}
+
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.amount > 0
+}
+
+func (m Money) Neg() Money {
+	return Money{amount: -m.amount, currency: m.currency}
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.currency != other.currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	sum := m.amount + other.amount
+	if (other.amount > 0 && sum < m.amount) || (other.amount < 0 && sum > m.amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return Money{amount: sum, currency: m.currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(other.Neg())
+}
+
+// Cmp returns -1, 0 or +1 like big.Int.Cmp
+func (m Money) Cmp(other Money) (int, error) {
+	if m.currency != other.currency {
+		return 0, ErrCurrencyMismatch
+	}
+
+	switch {
+	case m.amount < other.amount:
+		return -1, nil
+	case m.amount > other.amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Mul multiplies by an exact rate, rounding half to even to the currency's
+// minor unit
+func (m Money) Mul(rate *big.Rat) (Money, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.amount), rate)
+
+	rounded := roundHalfEven(product)
+	if !rounded.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return Money{amount: rounded.Int64(), currency: m.currency}, nil
+}
+
+// Split divides the amount into n parts that add up to it exactly
+func (m Money) Split(n int) ([]Money, error) {
+	if n < 1 {
+		return nil, errors.New("money: can't split into fewer than one part")
+	}
+
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+
+	return m.Allocate(ratios...)
+}
+
+// Allocate divides the amount in proportion to the ratios. Each share is
+// rounded half to even and whatever is left over from rounding goes, one
+// minor unit at a time, to the shares that were rounded the furthest, so the
+// parts always add up to the original amount
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	total := big.NewInt(0)
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, errors.New("money: ratios must not be negative")
+		}
+		total.Add(total, big.NewInt(r))
+	}
+	if total.Sign() == 0 {
+		return nil, errors.New("money: ratios must not all be zero")
+	}
+
+	type share struct {
+		index int
+		err   *big.Rat // exact share minus the rounded one
+	}
+
+	parts := make([]Money, len(ratios))
+	shares := make([]share, len(ratios))
+	left := m.amount
+
+	for i, r := range ratios {
+		exact := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(m.amount), big.NewInt(r)), total)
+		rounded := roundHalfEven(exact)
+
+		parts[i] = Money{amount: rounded.Int64(), currency: m.currency}
+		shares[i] = share{index: i, err: exact.Sub(exact, new(big.Rat).SetInt(rounded))}
+		left -= parts[i].amount
+	}
+
+	// left is at most a few units either way, hand it out by largest rounding
+	// error in the direction it's needed, earlier shares first on a tie
+	step := int64(1)
+	if left < 0 {
+		step = -1
+	}
+
+	slices.SortStableFunc(shares, func(a, b share) int {
+		if step > 0 {
+			return b.err.Cmp(a.err)
+		}
+		return a.err.Cmp(b.err)
+	})
+
+	for i := 0; left != 0; i++ {
+		parts[shares[i%len(shares)].index].amount += step
+		left -= step
+	}
+
+	return parts, nil
+}
+
+// roundHalfEven rounds x to the nearest integer, ties go to the even neighbour
+func roundHalfEven(x *big.Rat) *big.Int {
+	q, r := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+
+	// compare twice the remainder with the denominator to find which side of
+	// the halfway point we're on
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+
+	switch c := twice.Cmp(x.Denom()); {
+	case c > 0, c == 0 && q.Bit(0) == 1:
+		if x.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return q
+}
+
+// numericRat converts a numeric into an exact fraction, false when it isn't a
+// finite number
+func numericRat(v pgtype.Numeric) (*big.Rat, bool) {
+	if !v.Valid || v.NaN || v.InfinityModifier != pgtype.Finite {
+		return nil, false
+	}
+
+	r := new(big.Rat).SetInt(v.Int)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(max(v.Exp, -v.Exp))), nil))
+	if v.Exp < 0 {
+		r.Quo(r, scale)
+	} else {
+		r.Mul(r, scale)
+	}
+
+	return r, true
+}
+
+// ratNumeric rounds r half to even to scale decimal places
+func ratNumeric(r *big.Rat, scale int) pgtype.Numeric {
+	shift := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(shift))
+
+	return pgtype.Numeric{Int: roundHalfEven(scaled), Exp: int32(-scale), Valid: true}
+}
+
+// String formats the amount in major units, like "12.34 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency
+}
+
+// Decimal formats the amount in major units without the currency, like "-12.34"
+func (m Money) Decimal() string {
+	exp := CurrencyExponent(m.currency)
+
+	digits := new(big.Int).Abs(big.NewInt(m.amount)).String()
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	s := digits
+	if exp > 0 {
+		s = digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+	}
+
+	if m.amount < 0 {
+		s = "-" + s
+	}
+
+	return s
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// amounts are written as strings, so JSON clients that read numbers as
+// floats can't lose precision
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.currency})
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var v moneyJSON
+
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseMoney(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// NumericValue stores the amount in a numeric column in major units
+func (m Money) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{
+		Int:   big.NewInt(m.amount),
+		Exp:   int32(-CurrencyExponent(m.currency)),
+		Valid: true,
+	}, nil
+}
+
+// ScanNumeric reads a numeric column into the money's currency, which has to
+// be set beforehand with NewMoney since the column doesn't carry it
+func (m *Money) ScanNumeric(v pgtype.Numeric) error {
+	if m.currency == "" {
+		return errors.New("money: currency must be set before scanning")
+	}
+	if !v.Valid || v.NaN || v.InfinityModifier != pgtype.Finite {
+		return fmt.Errorf("money: can't scan %v", v)
+	}
+
+	n := new(big.Int).Set(v.Int)
+	shift := int(v.Exp) + CurrencyExponent(m.currency)
+
+	ten := big.NewInt(10)
+	switch {
+	case shift > 0:
+		n.Mul(n, new(big.Int).Exp(ten, big.NewInt(int64(shift)), nil))
+	case shift < 0:
+		var r big.Int
+		n.QuoRem(n, new(big.Int).Exp(ten, big.NewInt(int64(-shift)), nil), &r)
+		if r.Sign() != 0 {
+			return ErrMoneyPrecision
+		}
+	}
+
+	if !n.IsInt64() {
+		return ErrMoneyOverflow
+	}
+
+	m.amount = n.Int64()
+	return nil
+}
+
+// Validate makes Money a validation.Validatable, so ValidateStruct checks
+// the currency of every Money field
+func (m Money) Validate() error {
+	return validation.Validate(m.currency, validation.Required, is.CurrencyCode)
+}
+
+// rules for Money fields, used like validation.Field(&t.Amount, data.PositiveMoney)
+var (
+	PositiveMoney = validation.By(func(value any) error {
+		m, ok := moneyValue(value)
+		if !ok || !m.IsPositive() {
+			return errors.New("must be greater than zero")
+		}
+		return nil
+	})
+
+	NonNegativeMoney = validation.By(func(value any) error {
+		m, ok := moneyValue(value)
+		if !ok || m.IsNegative() {
+			return errors.New("must not be negative")
+		}
+		return nil
+	})
+)
+
+// MoneyIn only allows amounts in one of the currencies
+func MoneyIn(currencies ...string) validation.Rule {
+	return validation.By(func(value any) error {
+		m, ok := moneyValue(value)
+		if !ok || !slices.Contains(currencies, m.currency) {
+			return fmt.Errorf("currency must be one of %s", strings.Join(currencies, ", "))
+		}
+		return nil
+	})
+}
+
+func moneyValue(value any) (Money, bool) {
+	switch v := value.(type) {
+	case Money:
+		return v, true
+	case *Money:
+		if v == nil {
+			return Money{}, false
+		}
+		return *v, true
+	default:
+		return Money{}, false
+	}
+}
@@ -0,0 +1,368 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     Money
+		wantErr  error
+	}{
+		{name: "whole", amount: "12", currency: "USD", want: NewMoney(1200, "USD")},
+		{name: "cents", amount: "12.34", currency: "USD", want: NewMoney(1234, "USD")},
+		{name: "one decimal", amount: "12.3", currency: "USD", want: NewMoney(1230, "USD")},
+		{name: "trailing zeros", amount: "12.3400", currency: "USD", want: NewMoney(1234, "USD")},
+		{name: "negative", amount: "-12.34", currency: "USD", want: NewMoney(-1234, "USD")},
+		{name: "negative zero", amount: "-0", currency: "USD", want: NewMoney(0, "USD")},
+		{name: "lowercase currency", amount: "1", currency: "eur", want: NewMoney(100, "EUR")},
+		{name: "no minor unit", amount: "1000", currency: "JPY", want: NewMoney(1000, "JPY")},
+		{name: "no minor unit, zero decimals", amount: "1000.0", currency: "JPY", want: NewMoney(1000, "JPY")},
+		{name: "three decimals", amount: "1.234", currency: "KWD", want: NewMoney(1234, "KWD")},
+		{name: "largest", amount: "92233720368547758.07", currency: "USD", want: NewMoney(math.MaxInt64, "USD")},
+		{name: "too many decimals", amount: "12.345", currency: "USD", wantErr: ErrMoneyPrecision},
+		{name: "decimals on yen", amount: "1.5", currency: "JPY", wantErr: ErrMoneyPrecision},
+		{name: "too large", amount: "92233720368547758.08", currency: "USD", wantErr: ErrMoneyOverflow},
+		{name: "plus sign", amount: "+1", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "double minus", amount: "--1", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "sign after the point", amount: "1.-5", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "empty", amount: "", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "no whole part", amount: ".5", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "no decimals after the point", amount: "5.", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "exponent", amount: "1e3", currency: "USD", wantErr: ErrInvalidMoney},
+		{name: "not a number", amount: "ten", currency: "USD", wantErr: ErrInvalidMoney},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.amount, tt.currency)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(0, "USD"), "0.00"},
+		{NewMoney(5, "USD"), "0.05"},
+		{NewMoney(-5, "USD"), "-0.05"},
+		{NewMoney(123456, "USD"), "1234.56"},
+		{NewMoney(1000, "JPY"), "1000"},
+		{NewMoney(-1234, "KWD"), "-1.234"},
+		{NewMoney(math.MinInt64, "USD"), "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		got := tt.money.Decimal()
+		if got != tt.want {
+			t.Errorf("%d %s: got %q, want %q", tt.money.amount, tt.money.currency, got, tt.want)
+		}
+
+		// everything but the smallest amount reads back the same
+		if tt.money.amount != math.MinInt64 {
+			parsed, err := ParseMoney(got, tt.money.currency)
+			if err != nil || parsed != tt.money {
+				t.Errorf("%q parsed back as %v, %v", got, parsed, err)
+			}
+		}
+	}
+}
+
+func TestRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		num, denom int64
+		want       int64
+	}{
+		{0, 1, 0},
+		{1, 3, 0},
+		{2, 3, 1},
+		{-2, 3, -1},
+		{5, 2, 2},
+		{7, 2, 4},
+		{-5, 2, -2},
+		{-7, 2, -4},
+		{1, 2, 0},
+		{-1, 2, 0},
+		{26, 10, 3},
+		{-26, 10, -3},
+		{24, 10, 2},
+		{250001, 100000, 3},
+	}
+
+	for _, tt := range tests {
+		got := roundHalfEven(big.NewRat(tt.num, tt.denom))
+		if got.Int64() != tt.want {
+			t.Errorf("%d/%d: got %s, want %d", tt.num, tt.denom, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyAddSub(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		sub     bool
+		want    Money
+		wantErr error
+	}{
+		{name: "add", a: NewMoney(100, "USD"), b: NewMoney(23, "USD"), want: NewMoney(123, "USD")},
+		{name: "add negative", a: NewMoney(100, "USD"), b: NewMoney(-123, "USD"), want: NewMoney(-23, "USD")},
+		{name: "add up to the largest", a: NewMoney(math.MaxInt64-1, "USD"), b: NewMoney(1, "USD"), want: NewMoney(math.MaxInt64, "USD")},
+		{name: "add past the largest", a: NewMoney(math.MaxInt64, "USD"), b: NewMoney(1, "USD"), wantErr: ErrMoneyOverflow},
+		{name: "add past the smallest", a: NewMoney(math.MinInt64, "USD"), b: NewMoney(-1, "USD"), wantErr: ErrMoneyOverflow},
+		{name: "add other currency", a: NewMoney(1, "USD"), b: NewMoney(1, "EUR"), wantErr: ErrCurrencyMismatch},
+		{name: "sub", a: NewMoney(5, "USD"), b: NewMoney(7, "USD"), sub: true, want: NewMoney(-2, "USD")},
+		{name: "sub down to the smallest", a: NewMoney(math.MinInt64+1, "USD"), b: NewMoney(1, "USD"), sub: true, want: NewMoney(math.MinInt64, "USD")},
+		{name: "sub past the smallest", a: NewMoney(math.MinInt64, "USD"), b: NewMoney(1, "USD"), sub: true, wantErr: ErrMoneyOverflow},
+		{name: "sub the smallest", a: NewMoney(0, "USD"), b: NewMoney(math.MinInt64, "USD"), sub: true, wantErr: ErrMoneyOverflow},
+		{name: "sub other currency", a: NewMoney(1, "USD"), b: NewMoney(1, "EUR"), sub: true, wantErr: ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			var err error
+			if tt.sub {
+				got, err = tt.a.Sub(tt.b)
+			} else {
+				got, err = tt.a.Add(tt.b)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		ratios []int64
+		want   []int64
+	}{
+		{"even", 100, []int64{1, 1, 1, 1}, []int64{25, 25, 25, 25}},
+		{"remainder to the first", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"negative remainder to the first", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"half to even", 5, []int64{1, 1}, []int64{3, 2}},
+		{"less than a unit each", 1, []int64{1, 1, 1}, []int64{1, 0, 0}},
+		{"by ratio", 100, []int64{70, 20, 10}, []int64{70, 20, 10}},
+		{"remainder to the largest error", 100, []int64{1, 2}, []int64{33, 67}},
+		{"zero ratio", 100, []int64{0, 1}, []int64{0, 100}},
+		{"zero amount", 0, []int64{1, 2, 3}, []int64{0, 0, 0}},
+		{"many parts", 1001, []int64{1, 1, 1, 1, 1, 1, 1}, []int64{143, 143, 143, 143, 143, 143, 143}},
+		{"halves that round up", math.MaxInt64, []int64{1, 1}, []int64{math.MaxInt64 / 2, math.MaxInt64/2 + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := NewMoney(tt.amount, "USD").Allocate(tt.ratios...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]int64, len(parts))
+			sum := new(big.Int)
+			for i, p := range parts {
+				if p.Currency() != "USD" {
+					t.Errorf("part %d is in %s", i, p.Currency())
+				}
+				got[i] = p.Amount()
+				sum.Add(sum, big.NewInt(p.Amount()))
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if sum.Cmp(big.NewInt(tt.amount)) != 0 {
+				t.Errorf("parts add up to %s, want %d", sum, tt.amount)
+			}
+		})
+	}
+}
+
+func TestMoneySplit(t *testing.T) {
+	for _, amount := range []int64{0, 1, 7, 100, -100, 999_999_999} {
+		for n := 1; n <= 12; n++ {
+			parts, err := NewMoney(amount, "JPY").Split(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(parts) != n {
+				t.Fatalf("split %d into %d parts, got %d", amount, n, len(parts))
+			}
+
+			var sum int64
+			for _, p := range parts {
+				sum += p.Amount()
+
+				// shares of an even split never differ by more than a unit
+				if d := p.Amount() - amount/int64(n); d < -1 || d > 1 {
+					t.Errorf("split %d into %d: part %d is off by %d", amount, n, p.Amount(), d)
+				}
+			}
+
+			if sum != amount {
+				t.Errorf("split %d into %d: parts add up to %d", amount, n, sum)
+			}
+		}
+	}
+}
+
+func TestMoneyAllocateInvalid(t *testing.T) {
+	m := NewMoney(100, "USD")
+
+	if _, err := m.Split(0); err == nil {
+		t.Error("split into no parts")
+	}
+	if _, err := m.Allocate(1, -1); err == nil {
+		t.Error("allocated by a negative ratio")
+	}
+	if _, err := m.Allocate(0, 0); err == nil {
+		t.Error("allocated by zero ratios")
+	}
+	if _, err := m.Allocate(); err == nil {
+		t.Error("allocated without ratios")
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     Money
+		rate     string
+		currency string
+		want     Money
+	}{
+		{"same exponent", NewMoney(10000, "USD"), "0.9", "EUR", NewMoney(9000, "EUR")},
+		{"lowercase currency", NewMoney(10000, "USD"), "0.9", "eur", NewMoney(9000, "EUR")},
+		{"to no minor unit", NewMoney(101, "USD"), "150.5", "JPY", NewMoney(152, "JPY")},
+		{"to no minor unit, half to even", NewMoney(100, "USD"), "150.5", "JPY", NewMoney(150, "JPY")},
+		{"from no minor unit", NewMoney(1000, "JPY"), "0.0067", "USD", NewMoney(670, "USD")},
+		{"from three decimals", NewMoney(1000, "KWD"), "3.25", "USD", NewMoney(325, "USD")},
+		{"half down to even", NewMoney(1, "USD"), "0.5", "EUR", NewMoney(0, "EUR")},
+		{"half up to even", NewMoney(3, "USD"), "0.5", "EUR", NewMoney(2, "EUR")},
+		{"negative", NewMoney(-3, "USD"), "0.5", "EUR", NewMoney(-2, "EUR")},
+		{"smallest rate", NewMoney(1_000_000_000_000, "USD"), "0.000000000001", "EUR", NewMoney(1, "EUR")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := ParseRate(tt.rate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tt.from.Convert(rate, tt.currency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rate, _ := ParseRate("1000000")
+	_, err := NewMoney(math.MaxInt64/1000, "USD").Convert(rate, "EUR")
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("got error %v converting too much, want %v", err, ErrMoneyOverflow)
+	}
+}
+
+func TestMoneyNumeric(t *testing.T) {
+	amounts := []Money{
+		NewMoney(0, "USD"),
+		NewMoney(1234, "USD"),
+		NewMoney(-1234, "USD"),
+		NewMoney(1000, "JPY"),
+		NewMoney(1234, "KWD"),
+		NewMoney(math.MaxInt64, "USD"),
+		NewMoney(math.MinInt64, "USD"),
+	}
+
+	for _, m := range amounts {
+		v, err := m.NumericValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := NewMoney(0, m.Currency())
+
+		err = got.ScanNumeric(v)
+		if err != nil {
+			t.Fatalf("%v: %v", m, err)
+		}
+		if got != m {
+			t.Errorf("%v read back as %v", m, got)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		currency string
+		v        pgtype.Numeric
+		want     int64
+		wantErr  error
+	}{
+		{name: "extra zeros", currency: "USD", v: pgtype.Numeric{Int: big.NewInt(12340), Exp: -3, Valid: true}, want: 1234},
+		{name: "positive exponent", currency: "USD", v: pgtype.Numeric{Int: big.NewInt(5), Exp: 2, Valid: true}, want: 50000},
+		{name: "too many decimals", currency: "USD", v: pgtype.Numeric{Int: big.NewInt(12345), Exp: -3, Valid: true}, wantErr: ErrMoneyPrecision},
+		{name: "decimals on yen", currency: "JPY", v: pgtype.Numeric{Int: big.NewInt(15), Exp: -1, Valid: true}, wantErr: ErrMoneyPrecision},
+		{name: "too large", currency: "USD", v: pgtype.Numeric{Int: new(big.Int).Lsh(big.NewInt(1), 70), Exp: 0, Valid: true}, wantErr: ErrMoneyOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMoney(0, tt.currency)
+
+			err := m.ScanNumeric(tt.v)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && m.Amount() != tt.want {
+				t.Errorf("got %d, want %d", m.Amount(), tt.want)
+			}
+		})
+	}
+
+	invalid := []pgtype.Numeric{
+		{},
+		{NaN: true, Valid: true},
+		{InfinityModifier: pgtype.Infinity, Valid: true},
+	}
+
+	for _, v := range invalid {
+		m := NewMoney(0, "USD")
+		if err := m.ScanNumeric(v); err == nil {
+			t.Errorf("scanned %+v", v)
+		}
+	}
+
+	var unset Money
+	if err := unset.ScanNumeric(pgtype.Numeric{Int: big.NewInt(1), Valid: true}); err == nil {
+		t.Error("scanned without a currency")
+	}
+}
@@ -2,35 +2,155 @@ package data
 
 import (
 	"context"
-	"github.com/jackc/pgx/v5"
+	"errors"
+	"regexp"
+	"strings"
 	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrUnknownRole         = errors.New("unknown role")
+	ErrUnknownPermission   = errors.New("unknown permission")
+	ErrDuplicatePermission = errors.New("duplicate permission")
 )
 
+// codes are made of colon separated segments, like accounts:read. A segment
+// can be the * wildcard and a leading ! turns the code into a deny entry
+var permissionCodeRX = regexp.MustCompile(`^!?(\*|[a-z0-9_-]+)(:(\*|[a-z0-9_-]+))*$`)
+
+func ValidatePermissionCode(code string) error {
+	return validation.Validate(code,
+		validation.Required,
+		validation.Length(1, 100),
+		validation.Match(permissionCodeRX).Error("must be colon separated lowercase segments or *, optionally prefixed with !, like accounts:read"),
+	)
+}
+
+type Role struct {
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
 type Permissions []string
 
+// Include reports whether the permissions grant code.
+//
+// Permissions are hierarchical: accounts:read also grants accounts:read:own.
+// A * segment matches any single segment, and a trailing * matches one or
+// more, so accounts:* grants everything below accounts and *:read grants
+// reading anything. An entry prefixed with ! denies instead of granting.
+//
+// When both kinds of entries match, the most specific one wins, that is the
+// one matching more literal segments and then the longer one. Deny wins ties,
+// so !accounts:close beats accounts:close and *, but accounts:close:own is
+// still granted by an explicit accounts:close:own.
 func (p Permissions) Include(code string) bool {
-	for i := range p {
-		if code == p[i] {
-			return true
+	requested := strings.Split(code, ":")
+
+	var allow, deny specificity
+	var allowed, denied bool
+
+	for _, entry := range p {
+		pattern, isDeny := strings.CutPrefix(entry, "!")
+
+		s, ok := matchPermission(strings.Split(pattern, ":"), requested)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case isDeny && (!denied || s.moreSpecific(deny)):
+			deny, denied = s, true
+		case !isDeny && (!allowed || s.moreSpecific(allow)):
+			allow, allowed = s, true
+		}
+	}
+
+	switch {
+	case !allowed:
+		return false
+	case !denied:
+		return true
+	default:
+		return allow.moreSpecific(deny)
+	}
+}
+
+type specificity struct {
+	literals int
+	segments int
+}
+
+func (s specificity) moreSpecific(other specificity) bool {
+	if s.literals != other.literals {
+		return s.literals > other.literals
+	}
+	return s.segments > other.segments
+}
+
+func matchPermission(pattern, code []string) (specificity, bool) {
+	var s specificity
+
+	for i, segment := range pattern {
+		if i >= len(code) {
+			return s, false
+		}
+
+		switch {
+		case segment == "*":
+		case segment == code[i]:
+			s.literals++
+		default:
+			return s, false
 		}
+
+		s.segments++
 	}
-	return false
+
+	// whatever is left of code lies below the pattern in the hierarchy, which
+	// also makes a trailing wildcard match more than one segment
+	return s, true
+}
+
+type PermissionsRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	GetDirectForUser(ctx context.Context, userID int64) (Permissions, error)
+	GetAll(ctx context.Context) (Permissions, error)
+	Insert(ctx context.Context, code string) error
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+	GetAllRoles(ctx context.Context) ([]*Role, error)
+	AddRolesForUser(ctx context.Context, userID int64, roles ...string) error
+	RemoveRolesForUser(ctx context.Context, userID int64, roles ...string) error
+	GetRolesForUser(ctx context.Context, userID int64) ([]string, error)
 }
 
 type PermissionsModel struct {
-	DB *pgx.Conn
+	DB DBTX
 }
 
-func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns the effective permissions of the user, the ones
+// granted directly as well as the ones bundled in the user's roles
+func (m PermissionsModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
-		FROM permissons 
-		INNER JOIN users_permissons ON users_permissions.permission_id = permissions.id
-		INNER JOIN users_permissons ON users_permissions.user_id = users.id
-		WHERE users.id = $1
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.Query(ctx, query, userID)
@@ -59,15 +179,228 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
+// GetDirectForUser only returns the permissions granted to the user
+// individually, leaving out the ones that come from roles
+func (m PermissionsModel) GetDirectForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (m PermissionsModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (m PermissionsModel) Insert(ctx context.Context, code string) error {
+	query := `
+		INSERT INTO permissions (code)
+		VALUES ($1);
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, code)
+	if err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicatePermission
+		}
+		return err
+	}
+
+	return nil
+}
+
+// AddForUser grants the permissions to the user, ErrUnknownPermission is
+// returned without granting anything when one of the codes doesn't exist
+func (m PermissionsModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, `SELECT id FROM permissions WHERE code = ANY($1);`, codes)
+	if err != nil {
+		return err
+	}
+
+	permissionIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	if len(permissionIDs) != len(uniqueStrings(codes)) {
+		return ErrUnknownPermission
+	}
+
+	query := `
+		INSERT INTO users_permissions (user_id, permission_id)
+		SELECT $1, UNNEST($2::bigint[])
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = m.DB.Exec(ctx, query, userID, permissionIDs)
+	return err
+}
+
+func (m PermissionsModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
-		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)
 	`
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.Exec(ctx, query, userID, codes)
 	return err
 }
+
+func (m PermissionsModel) GetAllRoles(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.name
+		ORDER BY roles.name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.Name, &role.Permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddRolesForUser assigns the roles to the user, ErrUnknownRole is returned
+// without assigning anything when one of them doesn't exist
+func (m PermissionsModel) AddRolesForUser(ctx context.Context, userID int64, roles ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, `SELECT id FROM roles WHERE name = ANY($1);`, roles)
+	if err != nil {
+		return err
+	}
+
+	roleIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	if len(roleIDs) != len(uniqueStrings(roles)) {
+		return ErrUnknownRole
+	}
+
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		SELECT $1, UNNEST($2::bigint[])
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = m.DB.Exec(ctx, query, userID, roleIDs)
+	return err
+}
+
+func (m PermissionsModel) RemoveRolesForUser(ctx context.Context, userID int64, roles ...string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1
+		AND roles.name = ANY($2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID, roles)
+	return err
+}
+
+func (m PermissionsModel) GetRolesForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// revocationCache keeps the revocation list in memory so that checking an
+// access token on every request doesn't need a round trip to the database
+type revocationCache struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[int64]time.Time
+}
+
+func newRevocationCache() *revocationCache {
+	return &revocationCache{
+		tokens: make(map[string]time.Time),
+		users:  make(map[int64]time.Time),
+	}
+}
+
+type RevocationModel struct {
+	DB    DBTX
+	cache *revocationCache
+}
+
+// Revoke adds a single access token to the revocation list. The entry is only
+// kept until the token would have expired anyway.
+func (m RevocationModel) Revoke(ctx context.Context, jti string, userID int64, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, jti, userID, expiry)
+	if err != nil {
+		return err
+	}
+
+	m.cache.mu.Lock()
+	m.cache.tokens[jti] = expiry
+	m.cache.mu.Unlock()
+
+	return nil
+}
+
+// RevokeAllForUser revokes every access token issued to the user up to and
+// including the given time. Postgres keeps microseconds, so that's as precise
+// as the comparison in IsRevoked gets.
+func (m RevocationModel) RevokeAllForUser(ctx context.Context, userID int64, at time.Time) error {
+	at = at.Truncate(time.Microsecond)
+
+	query := `
+		INSERT INTO user_token_revocations (user_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before);
+	`
+
+	// the in-memory models only have the cache
+	if m.DB != nil {
+		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		_, err := m.DB.Exec(ctx, query, userID, at)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.cache.mu.Lock()
+	if at.After(m.cache.users[userID]) {
+		m.cache.users[userID] = at
+	}
+	m.cache.mu.Unlock()
+
+	return nil
+}
+
+// IsRevoked only consults the in-memory cache, Load keeps it in sync with the
+// revocations made by other instances.
+func (m RevocationModel) IsRevoked(jti string, userID int64, issuedAt time.Time) bool {
+	m.cache.mu.RLock()
+	defer m.cache.mu.RUnlock()
+
+	if _, ok := m.cache.tokens[jti]; ok {
+		return true
+	}
+
+	revokedBefore, ok := m.cache.users[userID]
+	return ok && !issuedAt.After(revokedBefore)
+}
+
+// Load drops expired entries from the revocation list and replaces the cache
+// with the current contents of the database.
+func (m RevocationModel) Load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, `DELETE FROM revoked_tokens WHERE expiry < $1;`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	tokens := make(map[string]time.Time)
+
+	rows, err := m.DB.Query(ctx, `SELECT jti, expiry FROM revoked_tokens;`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var jti string
+		var expiry time.Time
+
+		err := rows.Scan(&jti, &expiry)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		tokens[jti] = expiry
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	users := make(map[int64]time.Time)
+
+	rows, err = m.DB.Query(ctx, `SELECT user_id, revoked_before FROM user_token_revocations;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var revokedBefore time.Time
+
+		err := rows.Scan(&userID, &revokedBefore)
+		if err != nil {
+			return err
+		}
+
+		users[userID] = revokedBefore
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	m.cache.mu.Lock()
+	m.cache.tokens = tokens
+	m.cache.users = users
+	m.cache.mu.Unlock()
+
+	return nil
+}
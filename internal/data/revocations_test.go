@@ -0,0 +1,41 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevokeAllForUserSameSecond(t *testing.T) {
+	m := NewMemoryModels().Revocations
+
+	revokedAt := time.Date(2024, 3, 1, 12, 0, 0, 400*int(time.Millisecond), time.UTC)
+
+	err := m.RevokeAllForUser(context.Background(), 1, revokedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		userID   int64
+		issuedAt time.Time
+		want     bool
+	}{
+		{"issued earlier", 1, revokedAt.Add(-time.Minute), true},
+		{"issued earlier in the same second", 1, revokedAt.Add(-300 * time.Millisecond), true},
+		{"issued at the revocation", 1, revokedAt, true},
+		{"issued later in the same second", 1, revokedAt.Add(300 * time.Millisecond), false},
+		{"issued after", 1, revokedAt.Add(time.Minute), false},
+		{"another user", 2, revokedAt.Add(-time.Minute), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.IsRevoked("jti", tt.userID, tt.issuedAt)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
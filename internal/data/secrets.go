@@ -0,0 +1,64 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// SecretBox encrypts secrets the API has to read back, like TOTP secrets,
+// with AES-GCM before they are stored. A nil SecretBox stores them as they
+// are, which is only meant for development.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox takes a 16, 24 or 32 byte AES key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// seal encrypts plaintext bound to ad, a ciphertext copied over to another
+// row doesn't open. It reports whether it encrypted anything.
+func (b *SecretBox) seal(plaintext, ad []byte) ([]byte, bool, error) {
+	if b == nil || plaintext == nil {
+		return plaintext, false, nil
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, ad), true, nil
+}
+
+func (b *SecretBox) open(value, ad []byte, encrypted bool) ([]byte, error) {
+	if !encrypted || value == nil {
+		return value, nil
+	}
+
+	if b == nil {
+		return nil, errors.New("secret is encrypted but no key was given")
+	}
+
+	size := b.aead.NonceSize()
+	if len(value) < size {
+		return nil, errors.New("encrypted secret is too short")
+	}
+
+	return b.aead.Open(nil, value[:size], value[size:], ad)
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("12345678901234567890")
+
+	sealed, encrypted, err := box.seal(secret, []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !encrypted || bytes.Contains(sealed, secret) {
+		t.Fatalf("secret wasn't encrypted: %x", sealed)
+	}
+
+	opened, err := box.open(sealed, []byte("1"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, secret) {
+		t.Errorf("got %q, want %q", opened, secret)
+	}
+
+	// copied over to another user
+	_, err = box.open(sealed, []byte("2"), true)
+	if err == nil {
+		t.Error("opened a secret sealed for another user")
+	}
+
+	// stored before there was a key
+	opened, err = box.open(secret, []byte("1"), false)
+	if err != nil || !bytes.Equal(opened, secret) {
+		t.Errorf("got %q, %v for an unencrypted secret", opened, err)
+	}
+
+	var none *SecretBox
+
+	_, err = none.open(sealed, []byte("1"), true)
+	if err == nil {
+		t.Error("opened an encrypted secret without a key")
+	}
+}
@@ -0,0 +1,415 @@
+package data
+
+import (
+	"bankapi/internal/schedule"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StandingOrderStatusActive    = "active"
+	StandingOrderStatusFinished  = "finished"
+	StandingOrderStatusCancelled = "cancelled"
+
+	// schedules that run more often than this are refused
+	minStandingOrderInterval = time.Hour
+
+	// a transfer that keeps failing for other reasons than the payer's
+	// balance is retried this often, with the wait doubling from
+	// standingOrderRetryDelay, before the run is recorded as failed
+	maxStandingOrderAttempts = 5
+	standingOrderRetryDelay  = 5 * time.Minute
+)
+
+// ErrStandingOrderRetry is returned by RunNext when the transfer failed and
+// the run was put off to be tried again later
+var ErrStandingOrderRetry = errors.New("standing order run failed and will be retried")
+
+// StandingOrder transfers a fixed amount to another user on a schedule
+type StandingOrder struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	ToUserID    int64      `json:"to_user_id"`
+	Amount      Money      `json:"amount"`
+	Description string     `json:"description,omitempty"`
+	Schedule    string     `json:"schedule"`
+	NextRunAt   *time.Time `json:"next_run_at"`
+	RetryAt     *time.Time `json:"retry_at,omitempty"`
+	Attempts    int        `json:"-"`
+	EndAt       *time.Time `json:"end_at,omitempty"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Version     int        `json:"-"`
+}
+
+// StandingOrderRun records one scheduled run, failed runs keep the reason
+type StandingOrderRun struct {
+	ID              int64     `json:"id"`
+	StandingOrderID int64     `json:"standing_order_id"`
+	ScheduledFor    time.Time `json:"scheduled_for"`
+	Succeeded       bool      `json:"succeeded"`
+	Error           string    `json:"error,omitempty"`
+	TransferID      *int64    `json:"transfer_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func (o StandingOrder) Validate() error {
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.ToUserID, validation.Required, validation.NotIn(o.UserID).Error("can't transfer to yourself")),
+		validation.Field(&o.Amount, PositiveMoney),
+		validation.Field(&o.Description, validation.Length(0, 140)),
+		validation.Field(&o.Schedule, validation.Required, validation.By(func(any) error {
+			return validateSchedule(o.Schedule, o.EndAt)
+		})),
+	)
+}
+
+// validateSchedule checks the schedule still has a run before the order ends
+// and doesn't run too often
+func validateSchedule(spec string, endAt *time.Time) error {
+	s, err := schedule.Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	next := s.Next(now)
+	if next.IsZero() || (endAt != nil && next.After(*endAt)) {
+		return errors.New("has no runs in the future")
+	}
+
+	for range 24 {
+		after := s.Next(next)
+		if after.IsZero() {
+			break
+		}
+		if after.Sub(next) < minStandingOrderInterval {
+			return errors.New("must not run more than once an hour")
+		}
+		next = after
+	}
+
+	return nil
+}
+
+type StandingOrderModel struct {
+	DB DBTX
+}
+
+func (m StandingOrderModel) Insert(ctx context.Context, order *StandingOrder) error {
+	s, err := schedule.Parse(order.Schedule)
+	if err != nil {
+		return err
+	}
+
+	// stored in canonical form, so "weekly Monday" reads back as "weekly mon 00:00"
+	order.Schedule = s.String()
+	next := s.Next(time.Now())
+	order.NextRunAt = &next
+
+	query := `
+		INSERT INTO standing_orders (user_id, to_user_id, amount, currency, description, schedule, next_run_at, end_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, status, created_at, version;
+	`
+
+	args := []any{
+		order.UserID,
+		order.ToUserID,
+		order.Amount.Amount(),
+		order.Amount.Currency(),
+		order.Description,
+		order.Schedule,
+		order.NextRunAt,
+		order.EndAt,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&order.ID, &order.Status, &order.CreatedAt, &order.Version)
+}
+
+const standingOrderColumns = `id, user_id, to_user_id, amount, currency, description, schedule, next_run_at, retry_at, attempts, end_at, status, created_at, version`
+
+func scanStandingOrder(row pgx.Row, order *StandingOrder) error {
+	var amount int64
+	var currency string
+
+	err := row.Scan(
+		&order.ID,
+		&order.UserID,
+		&order.ToUserID,
+		&amount,
+		&currency,
+		&order.Description,
+		&order.Schedule,
+		&order.NextRunAt,
+		&order.RetryAt,
+		&order.Attempts,
+		&order.EndAt,
+		&order.Status,
+		&order.CreatedAt,
+		&order.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	order.Amount = NewMoney(amount, currency)
+	return nil
+}
+
+func (m StandingOrderModel) Get(ctx context.Context, id int64) (*StandingOrder, error) {
+	query := `SELECT ` + standingOrderColumns + ` FROM standing_orders WHERE id = $1`
+
+	var order StandingOrder
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := scanStandingOrder(m.DB.QueryRow(ctx, query, id), &order)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+func (m StandingOrderModel) GetAllForUser(ctx context.Context, userID int64) ([]*StandingOrder, error) {
+	query := `SELECT ` + standingOrderColumns + ` FROM standing_orders WHERE user_id = $1 ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*StandingOrder{}
+
+	for rows.Next() {
+		var order StandingOrder
+
+		err := scanStandingOrder(rows, &order)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, &order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (m StandingOrderModel) Cancel(ctx context.Context, order *StandingOrder) error {
+	query := `
+		UPDATE standing_orders
+		SET status = 'cancelled', next_run_at = NULL, version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING status, next_run_at, version;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, order.ID, order.Version).Scan(&order.Status, &order.NextRunAt, &order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunNext executes the standing order that has been due the longest and
+// returns it with the record of the run, or ErrRecordNotFound when nothing
+// is due. The order is locked with SKIP LOCKED, so any number of API
+// instances can run the scheduler side by side, and the transfer, the run
+// and moving the order on to its next run are committed together: a run
+// either happens exactly once or is retried as a whole.
+//
+// Runs missed while no scheduler was running are made up once, not once per
+// missed occurrence.
+//
+// A transfer refused for lack of funds, or because an account is frozen or
+// closed, is recorded as a failed run straight away. Any other failure puts
+// the run off with a growing delay, returning the order and
+// ErrStandingOrderRetry, so an order that can't be paid doesn't stay at the
+// front of the queue. After maxStandingOrderAttempts it is recorded as failed
+// as well and the order moves on to its next run.
+func (m StandingOrderModel) RunNext(ctx context.Context, now time.Time) (*StandingOrder, *StandingOrderRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT ` + standingOrderColumns + `
+		FROM standing_orders
+		WHERE status = 'active' AND COALESCE(retry_at, next_run_at) <= $1
+		ORDER BY COALESCE(retry_at, next_run_at), id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	var order StandingOrder
+
+	err = scanStandingOrder(tx.QueryRow(ctx, query, now), &order)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	run := &StandingOrderRun{
+		StandingOrderID: order.ID,
+		ScheduledFor:    *order.NextRunAt,
+	}
+
+	transfer := &Transfer{
+		FromUserID:  order.UserID,
+		ToUserID:    order.ToUserID,
+		Amount:      order.Amount,
+		Description: order.Description,
+	}
+
+	// the transfer gets a savepoint of its own, so a transfer that is refused
+	// still leaves the failed run to be recorded
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = createTransfer(ctx, savepoint, transfer)
+	switch {
+	case err == nil:
+		err = savepoint.Commit(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		run.Succeeded = true
+		run.TransferID = &transfer.ID
+	case errors.Is(err, ErrInsufficientFunds), errors.Is(err, ErrPostingCurrency), errors.Is(err, ErrAccountNotActive):
+		if err := savepoint.Rollback(ctx); err != nil {
+			return nil, nil, err
+		}
+		run.Error = err.Error()
+	case ctx.Err() != nil:
+		// shutting down or out of time, nothing was the order's fault
+		return nil, nil, err
+	default:
+		if err := savepoint.Rollback(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		order.Attempts++
+		if order.Attempts < maxStandingOrderAttempts {
+			return m.retryLater(ctx, tx, &order, now, err)
+		}
+
+		run.Error = fmt.Sprintf("transfer failed %d times: %s", order.Attempts, err)
+	}
+
+	query = `
+		INSERT INTO standing_order_runs (standing_order_id, scheduled_for, succeeded, error, transfer_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at;
+	`
+
+	args := []any{run.StandingOrderID, run.ScheduledFor, run.Succeeded, run.Error, run.TransferID}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&run.ID, &run.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := schedule.Parse(order.Schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next := s.Next(now)
+	if next.IsZero() || (order.EndAt != nil && next.After(*order.EndAt)) {
+		order.NextRunAt = nil
+		order.Status = StandingOrderStatusFinished
+	} else {
+		order.NextRunAt = &next
+	}
+
+	query = `
+		UPDATE standing_orders
+		SET next_run_at = $1, status = $2, attempts = 0, retry_at = NULL, version = version + 1
+		WHERE id = $3
+		RETURNING version;
+	`
+
+	err = tx.QueryRow(ctx, query, order.NextRunAt, order.Status, order.ID).Scan(&order.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order.Attempts = 0
+	order.RetryAt = nil
+
+	return &order, run, nil
+}
+
+// retryLater puts the order's current run off after the transfer failed with
+// cause, the delay doubles with every attempt
+func (m StandingOrderModel) retryLater(ctx context.Context, tx pgx.Tx, order *StandingOrder, now time.Time, cause error) (*StandingOrder, *StandingOrderRun, error) {
+	retryAt := now.Add(standingOrderRetryDelay << (order.Attempts - 1))
+	order.RetryAt = &retryAt
+
+	query := `
+		UPDATE standing_orders
+		SET attempts = $1, retry_at = $2, version = version + 1
+		WHERE id = $3
+		RETURNING version;
+	`
+
+	err := tx.QueryRow(ctx, query, order.Attempts, order.RetryAt, order.ID).Scan(&order.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return order, nil, fmt.Errorf("%w: %w", ErrStandingOrderRetry, cause)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/go-ozzo/ozzo-validation/v4"
@@ -14,14 +15,20 @@ import (
 const (
 	ScopeAuthentication = "authentication"
 	ScopeActivation     = "activation"
+	ScopeRefresh        = "refresh"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
+var ErrTokenReused = errors.New("token has already been used")
+
 type Token struct {
 	Token  string    `json:"token"`
 	Hash   []byte    `json:"-"`
 	UserID int64     `json:"-"`
 	Expiry time.Time `json:"expiry"`
 	Scope  string    `json:"-"`
+	Family []byte    `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -51,44 +58,172 @@ func (t Token) Validate() error {
 	)
 }
 
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	NewRefresh(ctx context.Context, userID int64, ttl time.Duration) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	Rotate(ctx context.Context, tokenPlaintext string, ttl time.Duration) (*Token, error)
+	DeleteForUser(ctx context.Context, scope string, userID int64) error
+	DeleteFamily(ctx context.Context, family []byte) error
+}
+
 type TokenModel struct {
-	DB *pgx.Conn
+	DB DBTX
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+// NewRefresh issues a refresh token that starts a new token family. Every
+// token handed out by Rotate afterwards belongs to the same family.
+func (m TokenModel) NewRefresh(ctx context.Context, userID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = make([]byte, 16)
+	_, err = rand.Read(token.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope) 
-		VALUES ($1, $2, $3, $4);
+		INSERT INTO tokens (hash, user_id, expiry, scope, family) 
+		VALUES ($1, $2, $3, $4, $5);
 	`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.Exec(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) DeleteForUser(scope string, userID int64) error {
+// Rotate exchanges a refresh token for a new one in the same family. A refresh
+// token can only be rotated once; presenting it again means it has leaked, so
+// the whole family is deleted and ErrTokenReused is returned.
+func (m TokenModel) Rotate(ctx context.Context, tokenPlaintext string, ttl time.Duration) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT user_id, expiry, family, used
+		FROM tokens
+		WHERE hash = $1 AND scope = $2
+		FOR UPDATE;
+	`
+
+	var current Token
+	var used bool
+
+	err = tx.QueryRow(ctx, query, tokenHash[:], ScopeRefresh).Scan(
+		&current.UserID,
+		&current.Expiry,
+		&current.Family,
+		&used,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if used {
+		_, err = tx.Exec(ctx, `DELETE FROM tokens WHERE family = $1;`, current.Family)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrTokenReused
+	}
+
+	if current.Expiry.Before(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE tokens SET used = true WHERE hash = $1;`, tokenHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(current.UserID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = current.Family
+
+	query = `
+		INSERT INTO tokens (hash, user_id, expiry, scope, family) 
+		VALUES ($1, $2, $3, $4, $5);
+	`
+
+	_, err = tx.Exec(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (m TokenModel) DeleteForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens 
 		WHERE SCOPE = $1 AND user_id = $2;
 	`
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.Exec(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteFamily removes every refresh token that was rotated from the same login
+func (m TokenModel) DeleteFamily(ctx context.Context, family []byte) error {
+	query := `
+		DELETE FROM tokens 
+		WHERE family = $1;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, family)
+	return err
+}
@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DirectionCredit = "credit"
+	DirectionDebit  = "debit"
+)
+
+// Transaction is one user's side of a journal entry, as shown in their history
+type Transaction struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"-"`
+	JournalEntryID int64     `json:"-"`
+	Direction      string    `json:"direction"`
+	Amount         Money     `json:"amount"`
+	CounterpartyID *int64    `json:"counterparty_id,omitempty"`
+	Counterparty   string    `json:"counterparty,omitempty"`
+	Description    string    `json:"description"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// TransactionFilters narrows down a user's history, zero values don't filter
+type TransactionFilters struct {
+	From           *time.Time
+	To             *time.Time
+	Currency       string
+	MinAmount      *Money
+	MaxAmount      *Money
+	Direction      string
+	CounterpartyID int64
+	Search         string
+	Page
+}
+
+func (f TransactionFilters) Validate() error {
+	return validation.ValidateStruct(&f,
+		validation.Field(&f.Currency, is.CurrencyCode, validation.When(f.MinAmount != nil || f.MaxAmount != nil, validation.Required.Error("is required to filter by amount"))),
+		validation.Field(&f.Direction, validation.In(DirectionCredit, DirectionDebit)),
+		validation.Field(&f.CounterpartyID, validation.Min(int64(0))),
+		validation.Field(&f.Search, validation.Length(0, 200)),
+		validation.Field(&f.To, validation.By(func(any) error {
+			if f.From != nil && f.To != nil && !f.To.After(*f.From) {
+				return fmt.Errorf("must be after from")
+			}
+			return nil
+		})),
+		validation.Field(&f.Page),
+	)
+}
+
+type TransactionModel struct {
+	DB DBTX
+}
+
+// insertTransactions records the history rows for a journal entry in the
+// transaction that posts it
+func insertTransactions(ctx context.Context, tx pgx.Tx, transactions ...*Transaction) error {
+	query := `
+		INSERT INTO transactions (user_id, journal_entry_id, direction, amount, currency, counterparty_id, counterparty, description)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE((SELECT username FROM users WHERE id = $6), ''), $7)
+		RETURNING id, counterparty, created_at;
+	`
+
+	for _, t := range transactions {
+		args := []any{t.UserID, t.JournalEntryID, t.Direction, t.Amount.Amount(), t.Amount.Currency(), t.CounterpartyID, t.Description}
+
+		err := tx.QueryRow(ctx, query, args...).Scan(&t.ID, &t.Counterparty, &t.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForUser returns one page of the user's history. Pages are found by
+// keyset rather than OFFSET, so going deep into years of history costs the
+// same as the first page
+func (m TransactionModel) GetAllForUser(ctx context.Context, userID int64, filters TransactionFilters) ([]*Transaction, Metadata, error) {
+	after, err := decodeCursor(filters.Cursor, filters.Sort)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	var minAmount, maxAmount *int64
+	if filters.MinAmount != nil {
+		minAmount = new(int64)
+		*minAmount = filters.MinAmount.Amount()
+	}
+	if filters.MaxAmount != nil {
+		maxAmount = new(int64)
+		*maxAmount = filters.MaxAmount.Amount()
+	}
+
+	args := []any{
+		userID,
+		filters.From,
+		filters.To,
+		filters.Currency,
+		minAmount,
+		maxAmount,
+		filters.Direction,
+		filters.CounterpartyID,
+		filters.Search,
+		filters.Limit + 1,
+	}
+
+	keyset := ""
+	if after != nil {
+		comparison := ">"
+		if direction == "DESC" {
+			comparison = "<"
+		}
+
+		var value any
+		switch column {
+		case "created_at":
+			value, err = time.Parse(time.RFC3339Nano, after.Value)
+		default:
+			value, err = strconv.ParseInt(after.Value, 10, 64)
+		}
+		if err != nil {
+			return nil, Metadata{}, ErrInvalidCursor
+		}
+
+		keyset = fmt.Sprintf("AND (%s, id) %s ($11::%s, $12::bigint)", column, comparison, map[string]string{"created_at": "timestamptz", "amount": "bigint"}[column])
+		args = append(args, value, after.ID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, user_id, journal_entry_id, direction, amount, currency, counterparty_id, counterparty, description, created_at
+		FROM transactions
+		WHERE user_id = $1
+		AND (created_at >= $2 OR $2 IS NULL)
+		AND (created_at < $3 OR $3 IS NULL)
+		AND (currency = $4 OR $4 = '')
+		AND (amount >= $5 OR $5 IS NULL)
+		AND (amount <= $6 OR $6 IS NULL)
+		AND (direction = $7 OR $7 = '')
+		AND (counterparty_id = $8 OR $8 = 0)
+		AND (search @@ plainto_tsquery('simple', $9) OR $9 = '')
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT $10
+	`, keyset, column, direction, direction)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	transactions := []*Transaction{}
+
+	for rows.Next() {
+		var t Transaction
+		var amount int64
+		var currency string
+
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.JournalEntryID,
+			&t.Direction,
+			&amount,
+			&currency,
+			&t.CounterpartyID,
+			&t.Counterparty,
+			&t.Description,
+			&t.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		t.Amount = NewMoney(amount, currency)
+		transactions = append(transactions, &t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{Sort: filters.Sort, Limit: filters.Limit}
+
+	// one row more than the limit was asked for to find out whether there is
+	// another page, without counting the whole history
+	if len(transactions) > filters.Limit {
+		transactions = transactions[:filters.Limit]
+		last := transactions[len(transactions)-1]
+
+		next := cursor{Sort: filters.Sort, ID: last.ID}
+		switch column {
+		case "created_at":
+			next.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		default:
+			next.Value = strconv.FormatInt(last.Amount.Amount(), 10)
+		}
+		metadata.NextCursor = next.encode()
+	}
+
+	return transactions, metadata, nil
+}
+
+// OpeningBalance sums the user's history in the currency up to before
+func (m TransactionModel) OpeningBalance(ctx context.Context, userID int64, currency string, before time.Time) (Money, error) {
+	query := `
+		SELECT COALESCE(SUM(CASE WHEN direction = 'credit' THEN amount ELSE -amount END), 0)
+		FROM transactions
+		WHERE user_id = $1 AND currency = $2 AND created_at < $3
+	`
+
+	var balance int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, userID, currency, before).Scan(&balance)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return NewMoney(balance, currency), nil
+}
+
+// Each calls fn with every transaction of the user in the currency between
+// from and to, oldest first, while reading them from the database. It's for
+// statements, which can be too long to hold in memory
+func (m TransactionModel) Each(ctx context.Context, userID int64, currency string, from, to time.Time, fn func(*Transaction) error) error {
+	query := `
+		SELECT id, user_id, journal_entry_id, direction, amount, currency, counterparty_id, counterparty, description, created_at
+		FROM transactions
+		WHERE user_id = $1 AND currency = $2 AND created_at >= $3 AND created_at < $4
+		ORDER BY created_at, id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID, currency, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Transaction
+		var amount int64
+
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.JournalEntryID,
+			&t.Direction,
+			&amount,
+			&currency,
+			&t.CounterpartyID,
+			&t.Counterparty,
+			&t.Description,
+			&t.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		t.Amount = NewMoney(amount, currency)
+
+		err = fn(&t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Signed returns the amount as it changes the balance, negative for debits
+func (t Transaction) Signed() Money {
+	if t.Direction == DirectionDebit {
+		return t.Amount.Neg()
+	}
+	return t.Amount
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jackc/pgx/v5"
+)
+
+// Transfer moves money from one user's balance to another's
+type Transfer struct {
+	ID             int64     `json:"id"`
+	FromUserID     int64     `json:"from_user_id"`
+	ToUserID       int64     `json:"to_user_id"`
+	Amount         Money     `json:"amount"`
+	Description    string    `json:"description,omitempty"`
+	JournalEntryID int64     `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (t Transfer) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.ToUserID, validation.Required, validation.NotIn(t.FromUserID).Error("can't transfer to yourself")),
+		validation.Field(&t.Amount, PositiveMoney),
+		validation.Field(&t.Description, validation.Length(0, 140)),
+	)
+}
+
+type TransferModel struct {
+	DB DBTX
+}
+
+// Create books the transfer in the ledger in one serializable transaction,
+// which is run again when it loses a race with a concurrent transfer. When
+// key is set, respond renders the response for it and the key is stored with
+// that response in the same transaction, so the transfer and the key either
+// both exist or neither does
+func (m TransferModel) Create(ctx context.Context, transfer *Transfer, key *IdempotencyKey, respond func(*Transfer) (int, []byte, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return retryTx(ctx, m.DB, pgx.Serializable, func(tx pgx.Tx) error {
+		err := createTransfer(ctx, tx, transfer)
+		if err != nil || key == nil {
+			return err
+		}
+
+		key.Status, key.Response, err = respond(transfer)
+		if err != nil {
+			return err
+		}
+
+		return insertIdempotencyKey(ctx, tx, key)
+	})
+}
+
+func createTransfer(ctx context.Context, tx pgx.Tx, transfer *Transfer) error {
+	from, err := userLedgerAccount(ctx, tx, transfer.FromUserID, transfer.Amount.Currency())
+	if err != nil {
+		return err
+	}
+
+	to, err := userLedgerAccount(ctx, tx, transfer.ToUserID, transfer.Amount.Currency())
+	if err != nil {
+		return err
+	}
+
+	entry := &JournalEntry{
+		Description: fmt.Sprintf("transfer from user %d to user %d", transfer.FromUserID, transfer.ToUserID),
+		Postings: []Posting{
+			{LedgerAccountID: from.ID, Amount: transfer.Amount.Neg()},
+			{LedgerAccountID: to.ID, Amount: transfer.Amount},
+		},
+	}
+
+	// postJournalEntry locks both balances in id order and rejects the
+	// entry if the sender's balance would go negative
+	err = postJournalEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO transfers (from_user_id, to_user_id, amount, currency, description, journal_entry_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at;
+	`
+
+	args := []any{transfer.FromUserID, transfer.ToUserID, transfer.Amount.Amount(), transfer.Amount.Currency(), transfer.Description, entry.ID}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	transfer.JournalEntryID = entry.ID
+
+	return insertTransactions(ctx, tx,
+		&Transaction{
+			UserID:         transfer.FromUserID,
+			JournalEntryID: entry.ID,
+			Direction:      DirectionDebit,
+			Amount:         transfer.Amount,
+			CounterpartyID: &transfer.ToUserID,
+			Description:    transfer.Description,
+		},
+		&Transaction{
+			UserID:         transfer.ToUserID,
+			JournalEntryID: entry.ID,
+			Direction:      DirectionCredit,
+			Amount:         transfer.Amount,
+			CounterpartyID: &transfer.FromUserID,
+			Description:    transfer.Description,
+		},
+	)
+}
+
+func (m TransferModel) Get(ctx context.Context, id int64) (*Transfer, error) {
+	query := `
+		SELECT id, from_user_id, to_user_id, amount, currency, description, journal_entry_id, created_at
+		FROM transfers
+		WHERE id = $1
+	`
+
+	var transfer Transfer
+	var amount int64
+	var currency string
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&transfer.ID,
+		&transfer.FromUserID,
+		&transfer.ToUserID,
+		&amount,
+		&currency,
+		&transfer.Description,
+		&transfer.JournalEntryID,
+		&transfer.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	transfer.Amount = NewMoney(amount, currency)
+	return &transfer, nil
+}
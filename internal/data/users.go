@@ -3,8 +3,8 @@ package data
 import (
 	"context"
 	"crypto/sha256"
-	"database/sql"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgerrcode"
@@ -26,13 +26,16 @@ var (
 var AnonymousUser = &Users{}
 
 type Users struct {
-	ID        int64     `json: "id"`
-	Username  string    `json: "name"`
-	Email     string    `json: "email"`
-	Password  password  `json: "-"`
-	Activated bool      `json: "activated"`
-	Version   int       `json: "-"`
-	CreatedAt time.Time `json: "created_at"`
+	ID           int64     `json:"id"`
+	Username     string    `json:"name"`
+	Email        string    `json:"email"`
+	Password     password  `json:"-"`
+	Activated    bool      `json:"activated"`
+	Version      int       `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	TOTPEnabled  bool      `json:"totp_enabled"`
+	TOTPSecret   []byte    `json:"-"`
+	TOTPLastStep int64     `json:"-"`
 }
 
 func (u *Users) IsAnonymous() bool {
@@ -94,19 +97,45 @@ func (p password) Validate() error {
 	)
 }
 
+// UserRepository is implemented by UserModel and by the in-memory store
+// handlers are tested against
+type UserRepository interface {
+	Get(ctx context.Context, id int64) (*Users, error)
+	Insert(ctx context.Context, user *Users) error
+	GetUserByEmail(ctx context.Context, email string) (*Users, error)
+	Update(ctx context.Context, user *Users) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*Users, error)
+	SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error
+	UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error)
+}
+
 type UserModel struct {
-	DB *pgx.Conn
+	DB      DBTX
+	secrets *SecretBox
 }
 
-func (m UserModel) Get(id int64) (*Users, error) {
+// openTOTPSecret decrypts the TOTP secret scanned into user, the ones stored
+// before there was a key stay readable until the user is next updated
+func (m UserModel) openTOTPSecret(user *Users, encrypted bool) error {
+	secret, err := m.secrets.open(user.TOTPSecret, []byte(strconv.FormatInt(user.ID, 10)), encrypted)
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = secret
+	return nil
+}
+
+func (m UserModel) Get(ctx context.Context, id int64) (*Users, error) {
 	query := `
-		SELECT id, created_at, username, email, password_hash, activated, version
+		SELECT id, created_at, username, email, password_hash, activated, version, totp_enabled, totp_secret, totp_secret_encrypted, totp_last_step
 		FROM users
 		WHERE id = $1`
 
 	var user Users
+	var encrypted bool
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRow(ctx, query, id).Scan(
@@ -117,20 +146,30 @@ func (m UserModel) Get(id int64) (*Users, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.TOTPEnabled,
+		&user.TOTPSecret,
+		&encrypted,
+		&user.TOTPLastStep,
 	)
 
 	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, pgx.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
 			return nil, err
 		}
 	}
+
+	err = m.openTOTPSecret(&user, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
-func (m UserModel) Insert(user *Users) error {
+func (m UserModel) Insert(ctx context.Context, user *Users) error {
 	query := `
 		INSERT INTO users (username, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -139,7 +178,7 @@ func (m UserModel) Insert(user *Users) error {
 
 	args := []any{user.Username, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -148,19 +187,23 @@ func (m UserModel) Insert(user *Users) error {
 		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
 			return ErrDuplicateEmail
 		}
+		return err
 	}
 
 	return nil
 }
 
-func (m UserModel) GetUserByEmail(email string) (*Users, error) {
+func (m UserModel) GetUserByEmail(ctx context.Context, email string) (*Users, error) {
 	query := `
-		SELECT * FROM users WHERE email = $1;
+		SELECT id, username, email, password_hash, activated, version, created_at, totp_enabled, totp_secret, totp_secret_encrypted, totp_last_step
+		FROM users
+		WHERE email = $1;
 	`
 
 	var user Users
+	var encrypted bool
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRow(ctx, query, email).Scan(
@@ -171,49 +214,69 @@ func (m UserModel) GetUserByEmail(email string) (*Users, error) {
 		&user.Activated,
 		&user.Version,
 		&user.CreatedAt,
+		&user.TOTPEnabled,
+		&user.TOTPSecret,
+		&encrypted,
+		&user.TOTPLastStep,
 	)
 
 	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, pgx.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
 			return nil, err
 		}
 	}
 
+	err = m.openTOTPSecret(&user, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
-func (m UserModel) Update(user *Users) error {
+func (m UserModel) Update(ctx context.Context, user *Users) error {
 	query := `
 		UPDATE users 
-		SET username = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 and version = $6
+		SET username = $1, email = $2, password_hash = $3, activated = $4,
+			totp_enabled = $5, totp_secret = $6, totp_secret_encrypted = $7, totp_last_step = $8, version = version + 1
+		WHERE id = $9 and version = $10
 		RETURNING version;
 	`
 
+	secret, encrypted, err := m.secrets.seal(user.TOTPSecret, []byte(strconv.FormatInt(user.ID, 10)))
+	if err != nil {
+		return err
+	}
+
 	args := []any{
 		user.Username,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.TOTPEnabled,
+		secret,
+		encrypted,
+		user.TOTPLastStep,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRow(ctx, query, args...).Scan(&user.Version)
+	err = m.DB.QueryRow(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		var e *pgconn.PgError
 		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
 			return ErrDuplicateEmail
 		}
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+		// the version no longer matches, someone else updated the user first
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -222,10 +285,11 @@ func (m UserModel) Update(user *Users) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*Users, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*Users, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
-		SELECT users.id, users.username, users.email, users.password_hash, users.activated, users.version, users.created_at 
+		SELECT users.id, users.username, users.email, users.password_hash, users.activated, users.version, users.created_at,
+			users.totp_enabled, users.totp_secret, users.totp_secret_encrypted, users.totp_last_step
 		FROM users
 		INNER JOIN tokens 
 		ON users.id = tokens.user_id
@@ -237,8 +301,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*Users, error
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 
 	var user Users
+	var encrypted bool
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRow(ctx, query, args...).Scan(
@@ -249,16 +314,25 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*Users, error
 		&user.Activated,
 		&user.Version,
 		&user.CreatedAt,
+		&user.TOTPEnabled,
+		&user.TOTPSecret,
+		&encrypted,
+		&user.TOTPLastStep,
 	)
 
 	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, pgx.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
 			return nil, err
 		}
 	}
 
+	err = m.openTOTPSecret(&user, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
@@ -0,0 +1,262 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrUnknownKey      = errors.New("unknown signing key")
+	ErrUnsupportedKey  = errors.New("unsupported key type, expected RSA or Ed25519")
+	ErrAlgorithmDenied = errors.New("token algorithm doesn't match the signing key")
+)
+
+// Key is a single public key, together with its private half when this
+// instance is allowed to sign with it.
+type Key struct {
+	ID      string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+// KeySet signs tokens with exactly one key but verifies tokens against every
+// key it knows about, so a new signing key can be rolled out while tokens
+// signed with the previous one are still accepted.
+type KeySet struct {
+	signing *Key
+	keys    []*Key
+}
+
+// Load reads the signing key from a PEM encoded private key and any extra
+// verification keys from PEM encoded public or private keys.
+func Load(signingKeyFile string, verificationKeyFiles ...string) (*KeySet, error) {
+	signing, err := readKey(signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if signing.private == nil {
+		return nil, fmt.Errorf("%s doesn't contain a private key", signingKeyFile)
+	}
+
+	ks := &KeySet{signing: signing, keys: []*Key{signing}}
+
+	for _, file := range verificationKeyFiles {
+		key, err := readKey(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// only the signing key is allowed to sign
+		key.private = nil
+		ks.add(key)
+	}
+
+	return ks, nil
+}
+
+// Generate creates a key set around a fresh Ed25519 key. Tokens signed with it
+// stop verifying as soon as the process exits, so it's only meant for development.
+func Generate() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newKey(public, private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySet{signing: key, keys: []*Key{key}}, nil
+}
+
+func (ks *KeySet) add(key *Key) {
+	for _, k := range ks.keys {
+		if k.ID == key.ID {
+			return
+		}
+	}
+
+	ks.keys = append(ks.keys, key)
+}
+
+// Sign signs the claims with the current signing key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.ID
+
+	return token.SignedString(ks.signing.private)
+}
+
+// Keyfunc is passed to jwt.Parse to pick the verification key named by the kid
+// header. The alg header has to match the key, otherwise a token could pick
+// the algorithm used to check its own signature.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	for _, key := range ks.keys {
+		if key.ID != kid {
+			continue
+		}
+
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, ErrAlgorithmDenied
+		}
+
+		return key.public, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JWK is the public part of a key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type Document struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Document returns every verification key, ready to be served as jwks.json
+func (ks *KeySet) Document() Document {
+	doc := Document{Keys: make([]JWK, 0, len(ks.keys))}
+
+	for _, key := range ks.keys {
+		jwk := publicJWK(key.public)
+		jwk.Use = "sig"
+		jwk.Alg = key.method.Alg()
+		jwk.Kid = key.ID
+
+		doc.Keys = append(doc.Keys, jwk)
+	}
+
+	return doc
+}
+
+func readKey(file string) (*Key, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%s doesn't contain a PEM encoded key", file)
+	}
+
+	var parsed any
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var key *Key
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key, err = newKey(&k.PublicKey, k)
+	case ed25519.PrivateKey:
+		key, err = newKey(k.Public(), k)
+	default:
+		key, err = newKey(k, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return key, nil
+}
+
+func newKey(public crypto.PublicKey, private crypto.PrivateKey) (*Key, error) {
+	key := &Key{public: public, private: private}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.Size()*8 < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	key.ID = thumbprint(public)
+	return key, nil
+}
+
+// thumbprint derives the kid from the key itself (RFC 7638) so every instance
+// loading the same PEM file agrees on it
+func thumbprint(public crypto.PublicKey) string {
+	jwk := publicJWK(public)
+
+	// the members have to be in lexicographic order, which is what
+	// encoding/json does for maps
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	default:
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+
+	js, _ := json.Marshal(members)
+	sum := sha256.Sum256(js)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func publicJWK(public crypto.PublicKey) JWK {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}
+	}
+
+	return JWK{}
+}
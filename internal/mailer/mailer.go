@@ -2,13 +2,16 @@ package mailer
 
 import (
 	"bytes"
-	"fmt"
+	"embed"
 	"html/template"
 	"time"
 
 	"github.com/go-mail/mail/v2"
 )
 
+//go:embed "templates"
+var templateFS embed.FS
+
 type Mailer struct {
 	dialer *mail.Dialer
 	sender string
@@ -25,10 +28,8 @@ func New(host string, port int, username, password, sender string) Mailer {
 }
 
 func (m Mailer) Send(recipient, templateFile string, data any) error {
-	tmplPath := "internal/mailer/templates/welcome.tmpl"
-	tmpl, err := template.ParseFiles(tmplPath)
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		fmt.Println("Hello World")
 		return err
 	}
 
@@ -39,13 +40,13 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	}
 
 	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "subject", data)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
 		return err
 	}
 
 	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "subject", data)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
 		return err
 	}
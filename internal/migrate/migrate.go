@@ -0,0 +1,325 @@
+// Package migrate applies the numbered SQL migrations in db/migrations. It
+// keeps track of them in the same schema_migrations table golang-migrate uses,
+// so databases migrated with either tool can be handled by the other.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NilVersion is the version of a database no migration has been applied to
+const NilVersion int64 = -1
+
+// lockID is the key of the advisory lock held while migrating, so instances
+// started at the same time with -migrate-on-start take turns
+const lockID int64 = 7_452_081_916
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+var ErrNoDown = errors.New("migration has no down file")
+
+// ErrDirty means a migration failed half way. The database has to be fixed by
+// hand and the version set with Force before migrating again
+type ErrDirty struct {
+	Version int64
+}
+
+func (e ErrDirty) Error() string {
+	return fmt.Sprintf("database is dirty at version %d, fix it and run force", e.Version)
+}
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	DB         *pgxpool.Pool
+	Migrations []Migration
+}
+
+// New reads the migrations from the *.up.sql and *.down.sql files at the top
+// of fsys
+func New(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is used by %q as well", entry.Name(), version, m.Name)
+		}
+
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Version returns the version the database is at and whether the migration
+// to it failed
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	err := ensureTable(ctx, m.DB)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version(ctx, m.DB)
+}
+
+// Up applies every migration newer than the database and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty{Version: current}
+		}
+
+		for _, mig := range m.Migrations {
+			if mig.Version <= current {
+				continue
+			}
+
+			err = run(ctx, conn, mig.Version, mig.Up)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+
+			applied = append(applied, mig)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the n newest applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty{Version: current}
+		}
+		if current == NilVersion {
+			return nil
+		}
+
+		i := slices.IndexFunc(m.Migrations, func(mig Migration) bool { return mig.Version == current })
+		if i < 0 {
+			return fmt.Errorf("database is at version %d which has no migration file", current)
+		}
+
+		for ; i >= 0 && len(reverted) < n; i-- {
+			mig := m.Migrations[i]
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, ErrNoDown)
+			}
+
+			// like golang-migrate the version recorded is the one being
+			// migrated to, it's marked dirty if the down migration fails
+			previous := NilVersion
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+
+			err = run(ctx, conn, previous, mig.Down)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+
+			reverted = append(reverted, mig)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Force sets the version without running any migration and clears the dirty
+// flag. NilVersion empties the table
+func (m *Migrator) Force(ctx context.Context, v int64) error {
+	if v < NilVersion {
+		return fmt.Errorf("invalid version %d", v)
+	}
+
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		err = setVersion(ctx, tx, v, false)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	})
+}
+
+// locked runs fn on a connection holding the migration lock. Session advisory
+// locks belong to a connection, so everything has to go through this one
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.DB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return fmt.Errorf("couldn't take the migration lock: %w", err)
+	}
+
+	defer func() {
+		// a fresh context so the lock is given back even when ctx is done
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+		if err != nil {
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	err = ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// the same table golang-migrate's postgres driver creates
+func ensureTable(ctx context.Context, db querier) error {
+	_, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	return err
+}
+
+func version(ctx context.Context, db querier) (int64, bool, error) {
+	var v int64
+	var dirty bool
+
+	err := db.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return NilVersion, false, nil
+		}
+		return 0, false, err
+	}
+
+	return v, dirty, nil
+}
+
+func setVersion(ctx context.Context, db querier, v int64, dirty bool) error {
+	_, err := db.Exec(ctx, `TRUNCATE schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	// a dirty NilVersion is kept so a failed down of the first migration
+	// isn't mistaken for a clean database
+	if v == NilVersion && !dirty {
+		return nil
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, v, dirty)
+	return err
+}
+
+// run marks the database dirty at version v, then runs the migration and
+// clears the flag in one transaction. If the migration fails the dirty flag
+// stays behind, the same as with golang-migrate
+func run(ctx context.Context, conn *pgxpool.Conn, v int64, sql string) error {
+	err := setVersion(ctx, conn, v, true)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// no arguments, so pgx sends the file as it is and it can hold several
+	// statements
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	err = setVersion(ctx, tx, v, false)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,186 @@
+// Package schedule parses the schedules of standing orders and works out when
+// they next run. Schedules are written as text, all times are in UTC:
+//
+//	once 2026-11-01T09:00:00Z
+//	weekly mon 09:00
+//	monthly 31 09:00        (on the last day in shorter months)
+//	cron 0 9 * * 1-5
+//
+// The time of day is optional for weekly and monthly and defaults to 00:00.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule tells when something runs next
+type Schedule interface {
+	// Next returns the first run strictly after t, the zero time when there
+	// is none
+	Next(t time.Time) time.Time
+	String() string
+}
+
+func Parse(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, ErrInvalidSchedule
+	}
+
+	switch kind, args := strings.ToLower(fields[0]), fields[1:]; kind {
+	case "once":
+		if len(args) != 1 {
+			return nil, ErrInvalidSchedule
+		}
+
+		at, err := time.Parse(time.RFC3339, args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not an RFC 3339 time", ErrInvalidSchedule, args[0])
+		}
+
+		return Once{At: at.UTC()}, nil
+
+	case "weekly":
+		if len(args) > 2 {
+			return nil, ErrInvalidSchedule
+		}
+
+		day, ok := weekdays[strings.ToLower(args[0])]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not a weekday", ErrInvalidSchedule, args[0])
+		}
+
+		clock, err := parseClock(args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return Weekly{Day: day, Clock: clock}, nil
+
+	case "monthly":
+		if len(args) > 2 {
+			return nil, ErrInvalidSchedule
+		}
+
+		day, err := strconv.Atoi(args[0])
+		if err != nil || day < 1 || day > 31 {
+			return nil, fmt.Errorf("%w: day of month must be between 1 and 31", ErrInvalidSchedule)
+		}
+
+		clock, err := parseClock(args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return Monthly{Day: day, Clock: clock}, nil
+
+	case "cron":
+		return ParseCron(strings.Join(args, " "))
+
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidSchedule, kind)
+	}
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// Clock is a time of day
+type Clock struct {
+	Hour, Minute int
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+func parseClock(args []string) (Clock, error) {
+	if len(args) == 0 {
+		return Clock{}, nil
+	}
+
+	t, err := time.Parse("15:04", args[0])
+	if err != nil {
+		return Clock{}, fmt.Errorf("%w: %q is not a time of day like 09:00", ErrInvalidSchedule, args[0])
+	}
+
+	return Clock{Hour: t.Hour(), Minute: t.Minute()}, nil
+}
+
+// Once runs a single time
+type Once struct {
+	At time.Time
+}
+
+func (o Once) Next(t time.Time) time.Time {
+	if o.At.After(t) {
+		return o.At
+	}
+	return time.Time{}
+}
+
+func (o Once) String() string {
+	return "once " + o.At.Format(time.RFC3339)
+}
+
+type Weekly struct {
+	Day   time.Weekday
+	Clock Clock
+}
+
+func (w Weekly) Next(t time.Time) time.Time {
+	t = t.UTC()
+	days := (int(w.Day) - int(t.Weekday()) + 7) % 7
+
+	next := time.Date(t.Year(), t.Month(), t.Day()+days, w.Clock.Hour, w.Clock.Minute, 0, 0, time.UTC)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 7)
+	}
+
+	return next
+}
+
+func (w Weekly) String() string {
+	return fmt.Sprintf("weekly %s %s", strings.ToLower(w.Day.String()[:3]), w.Clock)
+}
+
+// Monthly runs on the same day every month. Months too short for the day run
+// on their last day instead, so day 31 means the end of every month
+type Monthly struct {
+	Day   int
+	Clock Clock
+}
+
+func (m Monthly) in(year int, month time.Month) time.Time {
+	// day 0 of the following month is the last day of this one
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return time.Date(year, month, min(m.Day, last), m.Clock.Hour, m.Clock.Minute, 0, 0, time.UTC)
+}
+
+func (m Monthly) Next(t time.Time) time.Time {
+	t = t.UTC()
+
+	next := m.in(t.Year(), t.Month())
+	if !next.After(t) {
+		next = m.in(t.Year(), t.Month()+1)
+	}
+
+	return next
+}
+
+func (m Monthly) String() string {
+	return fmt.Sprintf("monthly %d %s", m.Day, m.Clock)
+}
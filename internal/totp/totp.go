@@ -0,0 +1,101 @@
+// Package totp implements time-based one-time passwords as described in
+// RFC 6238, using the defaults every authenticator app understands:
+// HMAC-SHA1, six digits and a 30 second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30
+
+	// number of periods either side of the current one that are still
+	// accepted, to allow for clock drift on the user's device
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160 bit secret, the key size RFC 4226 recommends
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret returns the secret the way authenticator apps expect it to be typed in
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// ProvisioningURI builds the otpauth:// URI that is usually shown as a QR code
+func ProvisioningURI(issuer, account string, secret []byte) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Code returns the code for the period t falls in
+func Code(secret []byte, t time.Time) string {
+	return hotp(secret, uint64(t.Unix()/Period))
+}
+
+// Validate checks code against the periods around t. Codes from periods up to
+// and including lastStep are rejected so a code can't be replayed; on success
+// the matching period is returned and should be stored as the new lastStep.
+func Validate(secret []byte, code string, t time.Time, lastStep int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := t.Unix() / Period
+
+	for step := current - skew; step <= current+skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// hotp is the HMAC-based one-time password from RFC 4226
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}